@@ -2,18 +2,27 @@ package config
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/monopole/myrepos/internal/file"
 )
 
 type Config struct {
+	// Include lists other config files to merge into this one.
+	// Relative paths are relative to this file's directory.
+	// As in Path, variables and a leading '~' are expanded.
+	Include []file.Path `yaml:"include,omitempty"`
+
 	// Path is the root path to all local storage repos.
 	// It's specified outside of Layout to avoid extra indents.
 	// If the path lacks a leading '/', it will be interpreted
 	// as a path relative to value of the HOME environment
 	// variable. If HOME is undefined, '.' is used.
-	Path file.Path
+	// A leading '~', and environment variables written as
+	// $VAR or ${VAR}, are expanded first; using an undefined
+	// variable is an error.
+	Path file.Path `yaml:"path,omitempty"`
 
 	// Layout is the directory layout below Path.
 	//
@@ -26,23 +35,52 @@ type Config struct {
 	// Sometimes one wants a directory name that doesn't match
 	// an organization name, and/or one wants to indicate that
 	// the repository was forked from another organization.
-	// See the OrgName field description for notes on doing this.
-	Layout map[ServerDomain]map[OrgName][]RepoName
+	// See the OrgName field description for notes on doing this,
+	// and RepoSpec for notes on doing it for just one repo.
+	Layout map[ServerDomain]map[OrgName][]RepoSpec `yaml:"layout"`
 
 	// ServerOpts is a mapping from a git server domain name
 	// to optional details about the git server, like the scheme to
 	// use when cloning, what timeout to use, what port, etc.
-	ServerOpts map[ServerDomain]ServerOpts `yaml:"serverOpts"`
+	ServerOpts map[ServerDomain]ServerOpts `yaml:"serverOpts,omitempty"`
+
+	// OrgOpts maps a git server domain and an org to optional
+	// details about the org, e.g. tags for all its repos.
+	// Orgs are matched by directory name, so the key 'monopole'
+	// matches both 'monopole' and 'monopole|monopole' in Layout.
+	OrgOpts map[ServerDomain]map[OrgName]OrgOpts `yaml:"orgOpts,omitempty"`
+
+	// file is the file the config was loaded from, if any.
+	file file.Path
+
+	// strays are options for servers or orgs missing from the
+	// layout of the file holding them.  They're problems only if they're still
+	// missing after the file is merged with others.
+	strays []stray
+}
+
+// source names where the config came from, for error messages.
+func (c *Config) source() file.Path {
+	if c.file == "" {
+		return "config"
+	}
+	return c.file
 }
 
+// DefaultBranch is the assumed default branch of a repo whose
+// RepoName doesn't specify one, until the remote says otherwise.
+const DefaultBranch = "main"
+
 // ServerDomain is the domain of the git server (e.g. github.com).
 type ServerDomain string
 
 // RepoName is the name of a repository, e.g. kubectl.
 // It can optionally be followed by a pipe char ('|') and
 // then the name of the repo's default branch, e.g.
-// "kubectl|master". If there's no pipe char, the name of
-// the repo's default branch defaults to "main".
+// "kubectl|master", pinning it. If there's no pipe char,
+// the repo's default branch is the one its remote's HEAD
+// names, i.e. upstream's for a fork.
+// It's the short form of a RepoSpec.
 type RepoName string
 
 // OrgName names the git "organization".
@@ -69,17 +107,96 @@ type RepoName string
 // whatever reason, but the Go module path must be sticky
 // over time to avoid breaking Go import statements.
 // Redirection services mitigate organization name changes.
+//
+// Each part can be a path of nested groups, as on GitLab, e.g.
+// 'platform/backend', in which case the directory is nested too.
 type OrgName string
 
 // ServerOpts provides details about using the git server
 type ServerOpts struct {
 	// What port (if not the default port) to use in the git clone url.
-	Port int
+	Port int `yaml:"port,omitempty"`
 	// Specify "https" or "ssh".
-	Scheme string
+	Scheme string `yaml:"scheme,omitempty"`
 	// How long to wait for a git operation?  Use time.Duration
 	// format, e.g. '80s' or '10m'.
-	Timeout string
+	Timeout string `yaml:"timeout,omitempty"`
+	// How many times to retry a git operation that reads from
+	// the server, i.e. a fetch or ls-remote, if it fails for a
+	// transient reason, e.g. a network glitch or a timeout.
+	Retries int `yaml:"retries,omitempty"`
+	// How long to wait before the first retry, in time.Duration
+	// format.  The wait doubles before each subsequent retry.
+	RetryDelay string `yaml:"retryDelay,omitempty"`
+	// UrlTemplate, if set, is the shape of the server's repo
+	// urls, replacing the usual ssh or https shapes.  It holds
+	// the placeholders {org} and {repo}, and can hold {domain}
+	// and {port}, e.g.
+	//
+	//	ssh://gitea@{domain}:{port}/git/{org}/{repo}
+	//
+	// The template applies to both origin and upstream urls.
+	UrlTemplate string `yaml:"urlTemplate,omitempty"`
+	// Hooks run in each of the server's repos.
+	Hooks `yaml:",inline"`
+	// CloneOpts shape clones of the server's repos.
+	CloneOpts `yaml:",inline"`
+}
+
+// Hooks are shell commands run in a repo's directory, with the
+// server's timeout, after git has worked on the repo.  A hook can
+// hold several lines, e.g. as a YAML block scalar.  Hooks set at
+// the server, org and repo levels all run, in that order.
+type Hooks struct {
+	// PostClone runs after the repo is cloned.
+	PostClone string `yaml:"postClone,omitempty"`
+	// PostUpdate runs after the repo is rebased onto new commits.
+	PostUpdate string `yaml:"postUpdate,omitempty"`
+}
+
+// CloneOpts shape a new clone, e.g. to keep clones of big repos
+// small.  They only matter when cloning.  A repo's options
+// override its server's.
+type CloneOpts struct {
+	// Depth, if positive, makes a shallow clone holding
+	// just that many commits.  Rebasing deepens the clone
+	// if it needs more history.
+	Depth int `yaml:"depth,omitempty"`
+	// Filter makes a partial clone, e.g. 'blob:none' omits file
+	// contents until a checkout or diff needs them.
+	Filter string `yaml:"filter,omitempty"`
+	// SingleBranch fetches just the default branch.
+	SingleBranch bool `yaml:"singleBranch,omitempty"`
+	// Sparse lists the directories to check out, leaving
+	// the rest of the repo out of the working tree.
+	Sparse []string `yaml:"sparse,omitempty"`
+}
+
+// Over returns the options, with unset ones taken from base.
+func (co CloneOpts) Over(base CloneOpts) CloneOpts {
+	if co.Depth == 0 {
+		co.Depth = base.Depth
+	}
+	if co.Filter == "" {
+		co.Filter = base.Filter
+	}
+	co.SingleBranch = co.SingleBranch || base.SingleBranch
+	if len(co.Sparse) == 0 {
+		co.Sparse = base.Sparse
+	}
+	return co
+}
+
+// OrgOpts provides details about an org.
+type OrgOpts struct {
+	// Tags label all the org's repos, e.g. 'work' or 'oss',
+	// so they can be selected as a group.
+	Tags []string `yaml:"tags,omitempty"`
+	// NoPush keeps updates of the org's forks from pushing
+	// the default branch to origin.
+	NoPush bool `yaml:"noPush,omitempty"`
+	// Hooks run in each of the org's repos.
+	Hooks `yaml:",inline"`
 }
 
 func (on OrgName) Parse() (file.Path, OrgName, OrgName) {
@@ -99,3 +216,29 @@ func (d ServerDomain) WithPort(p int) string {
 	}
 	return string(d)
 }
+
+// UrlPlaceholders are the placeholders allowed in a UrlTemplate.
+var UrlPlaceholders = []string{"{domain}", "{port}", "{org}", "{repo}"}
+
+var placeholder = regexp.MustCompile(`{[^}]*}`)
+
+// CheckUrlTemplate returns an error if the template uses unknown
+// placeholders, or lacks {org} or {repo}.
+func CheckUrlTemplate(t string) error {
+	for _, p := range placeholder.FindAllString(t, -1) {
+		known := false
+		for _, q := range UrlPlaceholders {
+			known = known || p == q
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s in urlTemplate %q; use %s",
+				p, t, strings.Join(UrlPlaceholders, ", "))
+		}
+	}
+	for _, p := range []string{"{org}", "{repo}"} {
+		if !strings.Contains(t, p) {
+			return fmt.Errorf("urlTemplate %q lacks %s", t, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,801 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monopole/myrepos/internal/file"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is something wrong at some position in a config file.
+type Problem struct {
+	File file.Path
+	// Line and Column are 1-based; zero means unknown.
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (p *Problem) String() string {
+	switch {
+	case p.Line == 0:
+		return fmt.Sprintf("%s: %s", p.File, p.Msg)
+	case p.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Msg)
+	}
+}
+
+// Problems is a list of problems that's also an error.
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	lines := make([]string, len(ps))
+	for i := range ps {
+		lines[i] = ps[i].String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Load reads config files, and checks them more strictly than
+// yaml.Unmarshal would, e.g. reporting unknown keys rather than
+// ignoring them.  Files named in a config's Include field are
+// loaded too.  A path that's a directory loads all the config
+// files in it, and in its conf.d subdirectory, as one config;
+// see file.Path.ConfigFiles.  All the configs are merged into one; see Merge.
+// If the files can be read, but have problems, the error is a
+// Problems holding all of them.
+func Load(paths ...file.Path) (*Config, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no config files")
+	}
+	cs := make([]*Config, len(paths))
+	for i, p := range paths {
+		c, err := load(p, nil)
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+	c := cs[0]
+	if len(cs) > 1 {
+		var err error
+		if c, err = Merge(cs...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkStrays(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads one config file, and any files it includes.
+// The including argument lists the files whose includes
+// led to this one, to catch cycles.
+func load(p file.Path, including []file.Path) (*Config, error) {
+	if _, isDir := p.Exists(); isDir {
+		return loadDir(p, including)
+	}
+	abs, err := filepath.Abs(string(p))
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range including {
+		if q == file.Path(abs) {
+			return nil, fmt.Errorf("config file %q includes itself", p)
+		}
+	}
+	body, err := os.ReadFile(string(p))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %q", p)
+	}
+	c, err := parse(p, body, true)
+	if err != nil || len(c.Include) == 0 {
+		return c, err
+	}
+	cs := []*Config{c}
+	for _, raw := range c.Include {
+		inc, err := raw.Expand()
+		if err != nil {
+			return nil, err
+		}
+		if !inc.IsAbs() {
+			inc = file.Path(filepath.Dir(string(p))).Append(inc)
+		}
+		ic, err := load(inc, append(including, file.Path(abs)))
+		if err != nil {
+			if raw != inc {
+				err = fmt.Errorf("%w (included as %q)", err, raw)
+			}
+			return nil, err
+		}
+		cs = append(cs, ic)
+	}
+	merged, err := Merge(cs...)
+	if err != nil {
+		return nil, err
+	}
+	merged.file = p
+	return merged, nil
+}
+
+// loadDir loads the config files in a directory, merging them.
+func loadDir(dir file.Path, including []file.Path) (*Config, error) {
+	files, err := dir.ConfigFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config files in directory %q", dir)
+	}
+	cs := make([]*Config, len(files))
+	for i, f := range files {
+		if cs[i], err = load(f, including); err != nil {
+			return nil, err
+		}
+	}
+	merged, err := Merge(cs...)
+	if err != nil {
+		return nil, err
+	}
+	merged.file = dir
+	return merged, nil
+}
+
+// Parse is Load of one file that includes nothing,
+// with the file's contents already in hand.
+func Parse(p file.Path, body []byte) (*Config, error) {
+	return parse(p, body, false)
+}
+
+// parse parses one file.  If mergeable, and the file has no other
+// problems, problems with serverOpts for domains not in the file's
+// layout are put off until the file has been merged with others,
+// which might fix them.  If the file has other problems, there's
+// no merge, so they're all reported at once.
+func parse(p file.Path, body []byte, mergeable bool) (*Config, error) {
+	ck := &checker{file: p}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		ck.addYamlErr(err)
+		return nil, ck.problems
+	}
+	c := Config{file: p}
+	if len(doc.Content) == 0 {
+		// An empty file.
+		return &c, nil
+	}
+	root := doc.Content[0]
+	ck.checkTypes(root, reflect.TypeOf(c), "")
+	ck.checkValues(root)
+	if !mergeable || len(ck.problems) > 0 {
+		for _, st := range ck.strays {
+			ck.problems = append(ck.problems, st.problem)
+		}
+		ck.strays = nil
+	}
+	if len(ck.problems) > 0 {
+		// Decoding would likely fail, and report less precisely.
+		ck.sort()
+		return nil, ck.problems
+	}
+	if err := root.Decode(&c); err != nil {
+		ck.addYamlErr(err)
+		return nil, ck.problems
+	}
+	c.strays = ck.strays
+	return &c, nil
+}
+
+// stray is options for a server or org that isn't in the layout.
+type stray struct {
+	domain ServerDomain
+	// orgDir is empty for serverOpts.
+	orgDir  file.Path
+	problem *Problem
+}
+
+// checkStrays reports options for servers or orgs not in the layout.
+func (c *Config) checkStrays() error {
+	var problems Problems
+	for _, s := range c.strays {
+		orgs, ok := c.Layout[s.domain]
+		if ok && s.orgDir != "" {
+			ok = false
+			for orgName := range orgs {
+				if d, _, _ := orgName.Parse(); d == s.orgDir {
+					ok = true
+				}
+			}
+		}
+		if !ok {
+			problems = append(problems, s.problem)
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// checker accumulates problems found in one file.
+type checker struct {
+	file     file.Path
+	problems Problems
+	strays   []stray
+}
+
+func (ck *checker) addf(n *yaml.Node, format string, args ...interface{}) {
+	ck.problems = append(ck.problems, &Problem{
+		File:   ck.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// sort orders the problems by position.
+func (ck *checker) sort() {
+	sort.SliceStable(ck.problems, func(i, j int) bool {
+		pi, pj := ck.problems[i], ck.problems[j]
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+}
+
+var yamlLineNum = regexp.MustCompile(`line (\d+): `)
+
+// addYamlErr adds problems from a yaml package error, whose
+// messages carry line numbers, but not columns.
+func (ck *checker) addYamlErr(err error) {
+	msgs := []string{err.Error()}
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	}
+	for _, msg := range msgs {
+		p := &Problem{File: ck.file, Msg: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLineNum.FindStringSubmatchIndex(p.Msg); m != nil {
+			p.Line, _ = strconv.Atoi(p.Msg[m[2]:m[3]])
+			p.Msg = p.Msg[:m[0]] + p.Msg[m[1]:]
+		}
+		ck.problems = append(ck.problems, p)
+	}
+}
+
+// pair is a key and its value in a yaml mapping.
+type pair struct {
+	key, val *yaml.Node
+}
+
+// pairs returns the key value pairs of a mapping node.
+func pairs(n *yaml.Node) []pair {
+	var result []pair
+	if n == nil || n.Kind != yaml.MappingNode {
+		return result
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		result = append(result, pair{key: n.Content[i], val: resolve(n.Content[i+1])})
+	}
+	return result
+}
+
+// lookup returns the value of the key in a mapping node, or nil.
+func lookup(n *yaml.Node, key string) *yaml.Node {
+	for _, p := range pairs(n) {
+		if p.key.Value == key {
+			return p.val
+		}
+	}
+	return nil
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+// addFields adds the struct's fields to the map, keyed by the
+// keys that yaml.v3 uses for them, including inlined fields.
+func addFields(fields map[string]reflect.StructField, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("yaml") == "-" {
+			continue
+		}
+		if strings.Contains(f.Tag.Get("yaml"), ",inline") {
+			addFields(fields, f.Type)
+			continue
+		}
+		fields[yamlKey(f)] = f
+	}
+}
+
+// yamlKey returns the key that yaml.v3 uses for the field.
+func yamlKey(f reflect.StructField) string {
+	tag := f.Tag.Get("yaml")
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+var repoSpecType = reflect.TypeOf(RepoSpec{})
+
+// checkTypes checks that the node has the shape of type t,
+// reporting unknown keys and scalars of the wrong type.
+// The path describes the node's place in the file.
+func (ck *checker) checkTypes(n *yaml.Node, t reflect.Type, path string) {
+	n = resolve(n)
+	if isNull(n) {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == repoSpecType && n.Kind == yaml.ScalarNode {
+		// The string form; checkLayout checks it.
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			ck.addf(n, "expected a mapping at %s", describe(path))
+			return
+		}
+		fields := make(map[string]reflect.StructField)
+		addFields(fields, t)
+		for _, p := range pairs(n) {
+			f, ok := fields[p.key.Value]
+			if !ok {
+				ck.addf(p.key, "unknown key %q%s%s",
+					p.key.Value, describeIn(path), suggest(p.key.Value, fields))
+				continue
+			}
+			ck.checkTypes(p.val, f.Type, path+"."+p.key.Value)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			ck.addf(n, "expected a mapping at %s", describe(path))
+			return
+		}
+		for _, p := range pairs(n) {
+			ck.checkTypes(p.val, t.Elem(), path+"."+p.key.Value)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			ck.addf(n, "expected a list at %s", describe(path))
+			return
+		}
+		for i, c := range n.Content {
+			ck.checkTypes(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+			ck.addf(n, "expected an integer at %s", describe(path))
+		}
+	case reflect.Bool:
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" {
+			ck.addf(n, "expected true or false at %s", describe(path))
+		}
+	case reflect.String:
+		if n.Kind != yaml.ScalarNode {
+			ck.addf(n, "expected a string at %s", describe(path))
+		}
+	}
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "top level"
+	}
+	return strings.TrimPrefix(path, ".")
+}
+
+func describeIn(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " in " + describe(path)
+}
+
+// suggest returns a hint naming a known key close to the given one.
+func suggest(key string, fields map[string]reflect.StructField) string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.EqualFold(name, key) ||
+			editDistance(strings.ToLower(name), strings.ToLower(key)) <= 2 {
+			return fmt.Sprintf("; did you mean %q?", name)
+		}
+	}
+	return fmt.Sprintf("; known keys are %s", strings.Join(names, ", "))
+}
+
+// editDistance is the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// checkValues checks the values that yaml types alone can't.
+// It works on nodes rather than decoded values, so that it can
+// report positions, and run even if decoding would fail.
+func (ck *checker) checkValues(root *yaml.Node) {
+	ck.checkPath(lookup(root, "path"))
+	if include := lookup(root, "include"); include != nil && include.Kind == yaml.SequenceNode {
+		for _, n := range include.Content {
+			ck.checkPath(resolve(n))
+		}
+	}
+	layout := lookup(root, "layout")
+	ck.checkLayout(layout)
+	ck.checkServerOpts(lookup(root, "serverOpts"), layout)
+	ck.checkOrgOpts(lookup(root, "orgOpts"), layout)
+}
+
+// checkPath checks that the variables in a path are defined.
+func (ck *checker) checkPath(n *yaml.Node) {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return
+	}
+	if _, err := file.Path(n.Value).Expand(); err != nil {
+		ck.addf(n, "%v", err)
+	}
+}
+
+// checkLayout checks the layout.
+func (ck *checker) checkLayout(layout *yaml.Node) {
+	for _, server := range pairs(layout) {
+		ck.checkDomain(server.key)
+		// With nested groups, a repo's directory might
+		// also be the directory of an org.
+		orgDirs := make(map[string]bool)
+		for _, org := range pairs(server.val) {
+			dir, _, _ := OrgName(org.key.Value).Parse()
+			orgDirs[string(dir)] = true
+		}
+		for _, org := range pairs(server.val) {
+			ck.checkOrgName(org.key)
+			if org.val.Kind != yaml.SequenceNode {
+				continue
+			}
+			orgDir, _, _ := OrgName(org.key.Value).Parse()
+			seen := make(map[string]*yaml.Node)
+			for _, repo := range org.val.Content {
+				repo = resolve(repo)
+				var dir string
+				if repo.Kind == yaml.MappingNode {
+					dir = ck.checkRepoSpec(repo)
+				} else {
+					dir = ck.checkRepoName(repo)
+				}
+				if dir == "" {
+					continue
+				}
+				if prev, ok := seen[dir]; ok {
+					ck.addf(repo, "repo %q already listed at line %d", dir, prev.Line)
+					continue
+				}
+				seen[dir] = repo
+				if p := path.Join(string(orgDir), dir); orgDirs[p] {
+					ck.addf(repo, "repo directory %q is also an org directory", p)
+				}
+			}
+		}
+	}
+}
+
+func (ck *checker) checkDomain(n *yaml.Node) {
+	d := n.Value
+	switch {
+	case d == "":
+		ck.addf(n, "empty server domain")
+	case strings.Contains(d, "://"):
+		ck.addf(n, "server domain %q shouldn't include a scheme", d)
+	case strings.ContainsAny(d, "/:| \t"):
+		ck.addf(n, "server domain %q should be just a domain, e.g. github.com", d)
+	}
+}
+
+func (ck *checker) checkOrgName(n *yaml.Node) {
+	parts := strings.Split(n.Value, "|")
+	if len(parts) > 3 {
+		ck.addf(n, "org name %q has more than 3 '|'-separated parts", n.Value)
+		return
+	}
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			ck.addf(n, "org name %q has an empty part; "+
+				"use {dir}, {dir}|{origin} or {dir}|{origin}|{upstream}", n.Value)
+			return
+		}
+		if !isGroupPath(part) {
+			ck.addf(n, "org name %q has a bad group path %q; "+
+				"use e.g. {group} or {group}/{subgroup}", n.Value, part)
+			return
+		}
+	}
+}
+
+// isGroupPath is true if the org is a group name, or a path of
+// nested group names, e.g. platform/backend.
+func isGroupPath(org string) bool {
+	for _, g := range strings.Split(org, "/") {
+		if g == "" || g == "." || g == ".." || strings.ContainsAny(g, " \t") {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRepoName checks a repo name, returning the name without
+// any branch, or the empty string if there's a problem.
+func (ck *checker) checkRepoName(n *yaml.Node) string {
+	if n.Kind != yaml.ScalarNode || isNull(n) || n.Value == "" {
+		ck.addf(n, "empty repo name")
+		return ""
+	}
+	parts := strings.Split(n.Value, "|")
+	if len(parts) > 2 {
+		ck.addf(n, "repo name %q has more than one '|'; use {repo}|{branch}", n.Value)
+		return ""
+	}
+	if strings.TrimSpace(parts[0]) == "" {
+		ck.addf(n, "repo name %q has an empty name before the '|'", n.Value)
+		return ""
+	}
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) == "" {
+		ck.addf(n, "repo name %q has an empty branch after the '|'", n.Value)
+		return ""
+	}
+	if strings.ContainsAny(parts[0], "/ \t") {
+		ck.addf(n, "repo name %q contains a slash or space", parts[0])
+		return ""
+	}
+	return parts[0]
+}
+
+// checkRepoSpec checks the mapping form of a repo, returning the
+// name of its directory, or the empty string if there's a problem.
+// Unknown keys and wrong types are caught by checkTypes.
+func (ck *checker) checkRepoSpec(n *yaml.Node) string {
+	name := lookup(n, "name")
+	if name == nil || isNull(name) || strings.TrimSpace(name.Value) == "" {
+		ck.addf(n, "repo has no name")
+		return ""
+	}
+	ok := true
+	if strings.ContainsAny(name.Value, "/| \t") {
+		ck.addf(name, "repo name %q contains a slash, pipe or space", name.Value)
+		ok = false
+	}
+	for _, p := range pairs(n) {
+		v := p.val
+		if !ck.checkCloneOpt(p.key.Value, v) {
+			ok = false
+		}
+		if p.key.Value == "tags" {
+			ck.checkTags(v)
+		}
+		if v.Kind != yaml.ScalarNode {
+			continue
+		}
+		switch p.key.Value {
+		case "branch", "dir", "origin", "upstream":
+			if strings.TrimSpace(v.Value) == "" || isNull(v) {
+				ck.addf(v, "empty %s", p.key.Value)
+				ok = false
+			} else if !ck.checkRepoField(p.key.Value, v) {
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		return ""
+	}
+	if dir := lookup(n, "dir"); dir != nil {
+		return dir.Value
+	}
+	return name.Value
+}
+
+// checkTags checks a list of tags.
+func (ck *checker) checkTags(n *yaml.Node) {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, t := range n.Content {
+		t = resolve(t)
+		if t.Kind != yaml.ScalarNode {
+			continue
+		}
+		if t.Value == "" || strings.ContainsAny(t.Value, ", \t/|") {
+			ck.addf(t, "bad tag %q; use letters, digits, '-', '_' or '.'", t.Value)
+		}
+	}
+}
+
+// addStray notes options for an org or server that isn't in the
+// layout, which isn't a problem if another file provides it.
+func (ck *checker) addStray(n *yaml.Node, s stray, format string, args ...interface{}) {
+	s.problem = &Problem{
+		File: ck.file, Line: n.Line, Column: n.Column,
+		Msg: fmt.Sprintf(format, args...),
+	}
+	ck.strays = append(ck.strays, s)
+}
+
+// checkRepoField checks a string field of a repo's mapping form.
+func (ck *checker) checkRepoField(key string, v *yaml.Node) bool {
+	switch key {
+	case "dir":
+		if strings.ContainsAny(v.Value, "/| \t") {
+			ck.addf(v, "dir %q contains a slash, pipe or space", v.Value)
+			return false
+		}
+	case "origin", "upstream":
+		if strings.Contains(v.Value, "|") || !isGroupPath(v.Value) {
+			ck.addf(v, "bad %s %q; use e.g. {group} or {group}/{subgroup}", key, v.Value)
+			return false
+		}
+	}
+	return true
+}
+
+var cloneFilter = regexp.MustCompile(`^(blob:none|blob:limit=[0-9]+[kmg]?|tree:[0-9]+)$`)
+
+// checkCloneOpt checks the value of a CloneOpts field, returning
+// false if it's bad.  Other keys are ignored.
+func (ck *checker) checkCloneOpt(key string, v *yaml.Node) bool {
+	switch key {
+	case "depth":
+		if k, err := strconv.Atoi(v.Value); err == nil && k < 0 {
+			ck.addf(v, "invalid depth %d; use 0 or more", k)
+			return false
+		}
+	case "filter":
+		if v.Kind == yaml.ScalarNode && !cloneFilter.MatchString(v.Value) {
+			ck.addf(v, "unknown filter %q; use e.g. blob:none, blob:limit=1m or tree:0", v.Value)
+			return false
+		}
+	case "sparse":
+		ok := true
+		for _, d := range v.Content {
+			if d.Kind != yaml.ScalarNode {
+				continue
+			}
+			if !isSparseDir(d.Value) {
+				ck.addf(d, "bad sparse dir %q; use a relative path like docs or src/api", d.Value)
+				ok = false
+			}
+		}
+		return ok
+	}
+	return true
+}
+
+// isSparseDir is true if d is a clean, relative path
+// within a repo, e.g. 'src/api'.
+func isSparseDir(d string) bool {
+	if d == "" || path.IsAbs(d) || path.Clean(d) != d || d == "." ||
+		d == ".." || strings.HasPrefix(d, "../") {
+		return false
+	}
+	return !strings.ContainsAny(d, "\n*?[")
+}
+
+func (ck *checker) checkServerOpts(opts *yaml.Node, layout *yaml.Node) {
+	for _, server := range pairs(opts) {
+		if lookup(layout, server.key.Value) == nil {
+			ck.addStray(server.key, stray{domain: ServerDomain(server.key.Value)},
+				"serverOpts for %q, which isn't in the layout", server.key.Value)
+		}
+		for _, p := range pairs(server.val) {
+			switch p.key.Value {
+			case "timeout", "retryDelay":
+				ck.checkDuration(p.key.Value, p.val)
+			case "scheme":
+				if p.val.Value != "ssh" && p.val.Value != "https" {
+					ck.addf(p.val, "unknown scheme %q; use ssh or https", p.val.Value)
+				}
+			case "port":
+				// Non-integers are caught by checkTypes.
+				if k, err := strconv.Atoi(p.val.Value); err == nil && (k < 1 || k > 65535) {
+					ck.addf(p.val, "invalid port %d; use 1-65535", k)
+				}
+			case "retries":
+				if k, err := strconv.Atoi(p.val.Value); err == nil && k < 0 {
+					ck.addf(p.val, "invalid retries %d; use 0 or more", k)
+				}
+			case "urlTemplate":
+				if err := CheckUrlTemplate(p.val.Value); err != nil {
+					ck.addf(p.val, "%v", err)
+				}
+			default:
+				ck.checkCloneOpt(p.key.Value, p.val)
+			}
+		}
+	}
+}
+
+func (ck *checker) checkOrgOpts(opts *yaml.Node, layout *yaml.Node) {
+	for _, server := range pairs(opts) {
+		ck.checkDomain(server.key)
+		orgs := lookup(layout, server.key.Value)
+		for _, org := range pairs(server.val) {
+			ck.checkOrgName(org.key)
+			dir, _, _ := OrgName(org.key.Value).Parse()
+			if !hasOrgDir(orgs, dir) {
+				ck.addStray(org.key, stray{domain: ServerDomain(server.key.Value), orgDir: dir},
+					"orgOpts for %q on %q, which isn't in the layout", org.key.Value, server.key.Value)
+			}
+			ck.checkTags(lookup(org.val, "tags"))
+		}
+	}
+}
+
+// hasOrgDir is true if a mapping of org names to repos
+// has an org with the given directory.
+func hasOrgDir(orgs *yaml.Node, dir file.Path) bool {
+	for _, org := range pairs(orgs) {
+		if d, _, _ := OrgName(org.key.Value).Parse(); d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (ck *checker) checkDuration(key string, n *yaml.Node) {
+	d, err := time.ParseDuration(n.Value)
+	if err != nil {
+		ck.addf(n, "bad %s %q; use e.g. '80s' or '10m'", key, n.Value)
+		return
+	}
+	if d <= 0 {
+		ck.addf(n, "%s %q must be positive", key, n.Value)
+	}
+}
@@ -0,0 +1,212 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/monopole/myrepos/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGood(t *testing.T) {
+	c, err := Parse("good.yml", []byte(`
+path: myrepos
+layout:
+  github.com:
+    monopole:
+      - mdrip
+      - kubectl|master
+    sigs.k8s.io|monopole|kubernetes-sigs:
+      - kustomize
+serverOpts:
+  github.com:
+    timeout: 80s
+    retries: 2
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []RepoSpec{{Name: "mdrip"}, {Name: "kubectl", Branch: "master"}},
+		c.Layout["github.com"]["monopole"])
+	assert.Equal(t, "80s", c.ServerOpts["github.com"].Timeout)
+}
+
+func TestParseProblems(t *testing.T) {
+	_, err := Parse("bad.yml", []byte(`
+layuot: {}
+layout:
+  github.com:
+    monopole:
+      - mdrip
+      - mdrip
+      - a|b|c
+    a||b:
+      - x
+    kubernetes-sigs:
+      - name: kustomize
+        tags: ["bad tag", a/b]
+serverOpts:
+  github.com:
+    timeout: soon
+    scheme: ftp
+    port: 0
+  gitlab.com:
+    timout: 10m
+`))
+	var problems Problems
+	if assert.ErrorAs(t, err, &problems) {
+		var got []string
+		for _, p := range problems {
+			got = append(got, p.String())
+		}
+		assert.Equal(t, []string{
+			`bad.yml:2:1: unknown key "layuot"; did you mean "layout"?`,
+			`bad.yml:7:9: repo "mdrip" already listed at line 6`,
+			`bad.yml:8:9: repo name "a|b|c" has more than one '|'; use {repo}|{branch}`,
+			`bad.yml:9:5: org name "a||b" has an empty part; ` +
+				`use {dir}, {dir}|{origin} or {dir}|{origin}|{upstream}`,
+			`bad.yml:13:16: bad tag "bad tag"; use letters, digits, '-', '_' or '.'`,
+			`bad.yml:13:27: bad tag "a/b"; use letters, digits, '-', '_' or '.'`,
+			`bad.yml:16:14: bad timeout "soon"; use e.g. '80s' or '10m'`,
+			`bad.yml:17:13: unknown scheme "ftp"; use ssh or https`,
+			`bad.yml:18:11: invalid port 0; use 1-65535`,
+			`bad.yml:19:3: serverOpts for "gitlab.com", which isn't in the layout`,
+			`bad.yml:20:5: unknown key "timout" in serverOpts.gitlab.com; did you mean "timeout"?`,
+		}, got)
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := Parse("syntax.yml", []byte("layout:\n  a: [\n"))
+	assert.EqualError(t, err, "syntax.yml:2: did not find expected node content")
+}
+
+func TestParseRepoSpecs(t *testing.T) {
+	c, err := Parse("specs.yml", []byte(`
+layout:
+  github.com:
+    monopole:
+      - kubectl|master
+      - name: mdrip
+        dir: mdrip-old
+        upstream: kubernetes-sigs
+        depth: 1
+      - name: mdrip
+      - {name: shexec, skip: true}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []RepoSpec{
+		{Name: "kubectl", Branch: "master"},
+		{Name: "mdrip", Dir: "mdrip-old", Upstream: "kubernetes-sigs", CloneOpts: CloneOpts{Depth: 1}},
+		{Name: "mdrip"},
+		{Name: "shexec", Skip: true},
+	}, c.Layout["github.com"]["monopole"])
+
+	_, err = Parse("bad.yml", []byte(`
+layout:
+  github.com:
+    monopole:
+      - mdrip
+      - {dir: mdrip}
+      - {name: x, dir: mdrip, depth: -1}
+      - {name: y, dir: mdrip}
+`))
+	assert.EqualError(t, err, strings.Join([]string{
+		"bad.yml:6:9: repo has no name",
+		"bad.yml:7:38: invalid depth -1; use 0 or more",
+		`bad.yml:8:9: repo "mdrip" already listed at line 5`,
+	}, "\n"))
+}
+
+func TestParseOrgOpts(t *testing.T) {
+	c, err := Parse("orgs.yml", []byte(`
+layout:
+  github.com:
+    monopole|monopole: [mdrip]
+orgOpts:
+  github.com:
+    monopole: {}
+`))
+	assert.NoError(t, err)
+	assert.Contains(t, c.OrgOpts["github.com"], OrgName("monopole"))
+
+	_, err = Parse("orgs.yml", []byte(`
+layout:
+  github.com:
+    monopole: [mdrip]
+orgOpts:
+  github.com:
+    google:
+      tags: [work, "a b"]
+`))
+	assert.EqualError(t, err, strings.Join([]string{
+		`orgs.yml:7:5: orgOpts for "google" on "github.com", which isn't in the layout`,
+		`orgs.yml:8:20: bad tag "a b"; use letters, digits, '-', '_' or '.'`,
+	}, "\n"))
+}
+
+func TestParseCloneOpts(t *testing.T) {
+	c, err := Parse("clone.yml", []byte(`
+layout:
+  github.com:
+    monopole:
+      - name: big
+        filter: blob:limit=1m
+        singleBranch: true
+        sparse: [docs, src/api]
+serverOpts:
+  github.com:
+    depth: 10
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, CloneOpts{
+		Filter: "blob:limit=1m", SingleBranch: true, Sparse: []string{"docs", "src/api"}},
+		c.Layout["github.com"]["monopole"][0].CloneOpts)
+	assert.Equal(t, 10, c.ServerOpts["github.com"].Depth)
+
+	_, err = Parse("clone.yml", []byte(`
+layout:
+  github.com:
+    monopole:
+      - name: big
+        filter: blobs
+        sparse: [../up, /abs]
+serverOpts:
+  github.com:
+    depth: -1
+`))
+	assert.EqualError(t, err, `clone.yml:6:17: unknown filter "blobs"; `+
+		`use e.g. blob:none, blob:limit=1m or tree:0
+clone.yml:7:18: bad sparse dir "../up"; use a relative path like docs or src/api
+clone.yml:7:25: bad sparse dir "/abs"; use a relative path like docs or src/api
+clone.yml:10:12: invalid depth -1; use 0 or more`)
+}
+
+func TestParseUrlTemplate(t *testing.T) {
+	_, err := Parse("url.yml", []byte(`
+layout:
+  example.com:
+    monopole: [mdrip]
+serverOpts:
+  example.com:
+    urlTemplate: https://{host}/{org}/{repo}
+`))
+	assert.EqualError(t, err, `url.yml:7:18: unknown placeholder {host} in `+
+		`urlTemplate "https://{host}/{org}/{repo}"; use {domain}, {port}, {org}, {repo}`)
+}
+
+func TestParseNestedGroups(t *testing.T) {
+	_, err := Parse("groups.yml", []byte(`
+layout:
+  gitlab.com:
+    platform/backend: [api]
+    platform/backend/api: [x]
+    platform//frontend: [web]
+    tools|me/../tools: [lint]
+`))
+	assert.EqualError(t, err, strings.Join([]string{
+		`groups.yml:4:24: repo directory "platform/backend/api" is also an org directory`,
+		`groups.yml:6:5: org name "platform//frontend" has a bad group path "platform//frontend"; ` +
+			`use e.g. {group} or {group}/{subgroup}`,
+		`groups.yml:7:5: org name "tools|me/../tools" has a bad group path "me/../tools"; ` +
+			`use e.g. {group} or {group}/{subgroup}`,
+	}, "\n"))
+}
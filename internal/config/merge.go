@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Merge combines configs into one, returning Problems if they
+// conflict.  Configs conflict if they
+//
+//   - have different, non-empty root paths,
+//   - map one org directory to different origin or upstream orgs,
+//   - give one repo different settings, e.g. default branches,
+//   - set one server option to different values.
+//
+// Otherwise, layouts are combined, duplicate repos are dropped,
+// server options are combined field by field, and the tags of
+// an org or repo are combined.
+func Merge(cs ...*Config) (*Config, error) {
+	m := newMerger()
+	for _, c := range cs {
+		m.add(c)
+	}
+	if len(m.problems) > 0 {
+		return nil, m.problems
+	}
+	return m.result, nil
+}
+
+// setting is a value, and the file it came from.
+type setting struct {
+	value  string
+	source string
+}
+
+type merger struct {
+	result *Config
+	// path remembers where the root path came from.
+	path setting
+	// orgs maps domain/orgDir to the org name that claimed it.
+	orgs map[string]setting
+	// repos maps domain/orgDir/repoDir/field to the field's value.
+	repos map[string]setting
+	// repoIndex maps domain/orgDir/repoDir to the repo's
+	// index in its org's list.
+	repoIndex map[string]int
+	// opts maps domain/option to its value.
+	opts     map[string]setting
+	problems Problems
+}
+
+func newMerger() *merger {
+	return &merger{
+		result: &Config{
+			Layout:     make(map[ServerDomain]map[OrgName][]RepoSpec),
+			ServerOpts: make(map[ServerDomain]ServerOpts),
+			OrgOpts:    make(map[ServerDomain]map[OrgName]OrgOpts),
+		},
+		orgs:      make(map[string]setting),
+		repos:     make(map[string]setting),
+		repoIndex: make(map[string]int),
+		opts:      make(map[string]setting),
+	}
+}
+
+func (m *merger) conflict(c *Config, format string, args ...interface{}) {
+	m.problems = append(m.problems, &Problem{
+		File: c.source(), Msg: fmt.Sprintf(format, args...)})
+}
+
+// claim records the value of a setting, returning false
+// and reporting a conflict if it already has a different one.
+func (m *merger) claim(
+	c *Config, settings map[string]setting, key, value, what string) bool {
+	if old, ok := settings[key]; ok {
+		if old.value != value {
+			m.conflict(c, "%s is %q here, but %q in %s", what, value, old.value, old.source)
+			return false
+		}
+		return true
+	}
+	settings[key] = setting{value: value, source: string(c.source())}
+	return true
+}
+
+func (m *merger) add(c *Config) {
+	if c.Path != "" {
+		if m.path.value == "" {
+			m.path = setting{value: string(c.Path), source: string(c.source())}
+			m.result.Path = c.Path
+		} else if m.path.value != string(c.Path) {
+			m.conflict(c, "path is %q here, but %q in %s", c.Path, m.path.value, m.path.source)
+		}
+	}
+	for _, domain := range sortedDomains(c.Layout) {
+		for _, orgName := range sortedOrgs(c.Layout[domain]) {
+			repos := c.Layout[domain][orgName]
+			dir, origin, upstream := orgName.Parse()
+			orgKey := string(domain) + "/" + string(dir)
+			if !m.claim(c, m.orgs, orgKey,
+				string(origin)+"|"+string(upstream),
+				fmt.Sprintf("origin|upstream of org dir %s", orgKey)) {
+				continue
+			}
+			m.addRepos(c, domain, orgName, orgKey, repos)
+		}
+	}
+	for _, domain := range sortedDomains(c.ServerOpts) {
+		opts := c.ServerOpts[domain]
+		merged := m.result.ServerOpts[domain]
+		key, what := string(domain), "serverOpts %s of "+string(domain)
+		m.mergeInt(c, key, what, "port", &merged.Port, opts.Port)
+		m.mergeString(c, key, what, "scheme", &merged.Scheme, opts.Scheme)
+		m.mergeString(c, key, what, "timeout", &merged.Timeout, opts.Timeout)
+		m.mergeInt(c, key, what, "retries", &merged.Retries, opts.Retries)
+		m.mergeString(c, key, what, "retryDelay", &merged.RetryDelay, opts.RetryDelay)
+		m.mergeString(c, key, what, "urlTemplate", &merged.UrlTemplate, opts.UrlTemplate)
+		m.mergeHooks(c, key, what, &merged.Hooks, opts.Hooks)
+		m.mergeCloneOpts(c, key, what, &merged.CloneOpts, opts.CloneOpts)
+		m.result.ServerOpts[domain] = merged
+	}
+	for domain, orgs := range c.OrgOpts {
+		if m.result.OrgOpts[domain] == nil {
+			m.result.OrgOpts[domain] = make(map[OrgName]OrgOpts)
+		}
+		for _, orgName := range sortedOrgs(orgs) {
+			opts := orgs[orgName]
+			merged := m.result.OrgOpts[domain][orgName]
+			merged.Tags = unionTags(merged.Tags, opts.Tags)
+			merged.NoPush = merged.NoPush || opts.NoPush
+			dir, _, _ := orgName.Parse()
+			key := string(domain) + "/" + string(dir)
+			m.mergeHooks(c, key, "orgOpts %s of "+key, &merged.Hooks, opts.Hooks)
+			m.result.OrgOpts[domain][orgName] = merged
+		}
+	}
+	m.result.strays = append(m.result.strays, c.strays...)
+}
+
+func (m *merger) addRepos(
+	c *Config, domain ServerDomain, orgName OrgName, orgKey string, repos []RepoSpec) {
+	if m.result.Layout[domain] == nil {
+		m.result.Layout[domain] = make(map[OrgName][]RepoSpec)
+	}
+	// The org may have been claimed by an equivalent name, e.g.
+	// 'monopole' and 'monopole|monopole'.
+	for existing := range m.result.Layout[domain] {
+		if d, _, _ := existing.Parse(); string(domain)+"/"+string(d) == orgKey {
+			orgName = existing
+		}
+	}
+	list := m.result.Layout[domain][orgName]
+	for _, r := range repos {
+		key := orgKey + "/" + string(r.DirName())
+		k, seen := m.repoIndex[key]
+		for _, f := range []struct{ what, value string }{
+			{"name", r.Name},
+			{"origin", string(r.Origin)},
+			{"upstream", string(r.Upstream)},
+			{"depth", strconv.Itoa(r.Depth)},
+			{"filter", r.Filter},
+			{"singleBranch", strconv.FormatBool(r.SingleBranch)},
+			{"sparse", strings.Join(r.Sparse, ",")},
+			{"skip", strconv.FormatBool(r.Skip)},
+			{"noPush", strconv.FormatBool(r.NoPush)},
+			{"postClone", r.PostClone},
+			{"postUpdate", r.PostUpdate},
+		} {
+			m.claim(c, m.repos, key+"/"+f.what, f.value, f.what+" of repo "+key)
+		}
+		// A pinned default branch wins over an unpinned one.
+		pinned := r.Branch != "" && m.claim(
+			c, m.repos, key+"/default branch", r.Branch, "default branch of repo "+key)
+		if seen {
+			list[k].Tags = unionTags(list[k].Tags, r.Tags)
+			if pinned {
+				list[k].Branch = r.Branch
+			}
+			continue
+		}
+		m.repoIndex[key] = len(list)
+		list = append(list, r)
+	}
+	m.result.Layout[domain][orgName] = list
+}
+
+// mergeString merges one server or org option, where "" means
+// unset.  The key says whose option it is, and what formats a
+// description of it given its name.
+func (m *merger) mergeString(
+	c *Config, key, what, name string, dst *string, src string) {
+	if src == "" {
+		return
+	}
+	if m.claim(c, m.opts, key+"/"+name, src, fmt.Sprintf(what, name)) {
+		*dst = src
+	}
+}
+
+// mergeInt is mergeString for options where 0 means unset.
+func (m *merger) mergeInt(
+	c *Config, key, what, name string, dst *int, src int) {
+	if src == 0 {
+		return
+	}
+	if m.claim(c, m.opts, key+"/"+name, strconv.Itoa(src), fmt.Sprintf(what, name)) {
+		*dst = src
+	}
+}
+
+func (m *merger) mergeHooks(c *Config, key, what string, dst *Hooks, src Hooks) {
+	m.mergeString(c, key, what, "postClone", &dst.PostClone, src.PostClone)
+	m.mergeString(c, key, what, "postUpdate", &dst.PostUpdate, src.PostUpdate)
+}
+
+func (m *merger) mergeCloneOpts(
+	c *Config, key, what string, dst *CloneOpts, src CloneOpts) {
+	m.mergeInt(c, key, what, "depth", &dst.Depth, src.Depth)
+	m.mergeString(c, key, what, "filter", &dst.Filter, src.Filter)
+	if src.SingleBranch {
+		dst.SingleBranch = true
+	}
+	if len(src.Sparse) > 0 && m.claim(c, m.opts, key+"/sparse",
+		strings.Join(src.Sparse, ","), fmt.Sprintf(what, "sparse")) {
+		dst.Sparse = src.Sparse
+	}
+}
+
+// unionTags returns the tags in a, followed by those only in b.
+func unionTags(a, b []string) []string {
+	result := append([]string(nil), a...)
+	for _, t := range b {
+		found := false
+		for _, u := range a {
+			found = found || u == t
+		}
+		if !found {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func sortedDomains[V any](m map[ServerDomain]V) []ServerDomain {
+	result := make([]ServerDomain, 0, len(m))
+	for d := range m {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func sortedOrgs[V any](m map[OrgName]V) []OrgName {
+	result := make([]OrgName, 0, len(m))
+	for o := range m {
+		result = append(result, o)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,154 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/myrepos/internal/config"
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, files map[string]string) file.Path {
+	dir := t.TempDir()
+	for name, body := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644))
+	}
+	return file.Path(dir)
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yml": `
+include:
+  - work.yml
+path: myrepos
+layout:
+  github.com:
+    monopole:
+      - mdrip
+`,
+		"work.yml": `
+layout:
+  github.com:
+    monopole|monopole:
+      - mdrip
+      - myrepos
+  gitlab.com:
+    acme:
+      - widget|master
+serverOpts:
+  gitlab.com:
+    timeout: 5m
+`,
+	})
+	c, err := Load(dir.Append("main.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, file.Path("myrepos"), c.Path)
+	assert.Equal(t, []RepoSpec{{Name: "mdrip"}, {Name: "myrepos"}}, c.Layout["github.com"]["monopole"])
+	assert.Equal(t, []RepoSpec{{Name: "widget", Branch: "master"}}, c.Layout["gitlab.com"]["acme"])
+	assert.Equal(t, "5m", c.ServerOpts["gitlab.com"].Timeout)
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yml": "include: [b.yml]\n",
+		"b.yml": "include: [a.yml]\n",
+	})
+	_, err := Load(dir.Append("a.yml"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "includes itself")
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yml":        "path: myrepos\nlayout: {github.com: {monopole: [mdrip]}}\n",
+		"notes.txt":         "not a config",
+		"conf.d/work.yaml":  "layout: {gitlab.com: {acme: [widget]}}\n",
+		"conf.d/tuning.yml": "serverOpts: {gitlab.com: {retries: 3}}\n",
+	})
+	c, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, file.Path("myrepos"), c.Path)
+	assert.Equal(t, []RepoSpec{{Name: "mdrip"}}, c.Layout["github.com"]["monopole"])
+	assert.Equal(t, []RepoSpec{{Name: "widget"}}, c.Layout["gitlab.com"]["acme"])
+	assert.Equal(t, 3, c.ServerOpts["gitlab.com"].Retries)
+
+	_, err = Load(dir.Append("conf.d/empty"))
+	assert.Error(t, err)
+}
+
+func TestLoadMergesServerOptsFragment(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"layout.yml": "layout: {github.com: {monopole: [mdrip]}}\n",
+		"opts.yml":   "serverOpts: {github.com: {retries: 3}}\n",
+	})
+	c, err := Load(dir.Append("layout.yml"), dir.Append("opts.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, c.ServerOpts["github.com"].Retries)
+
+	// Alone, the fragment's options apply to nothing.
+	_, err = Load(dir.Append("opts.yml"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `serverOpts for "github.com", which isn't in the layout`)
+	}
+}
+
+func TestLoadNothing(t *testing.T) {
+	_, err := Load()
+	assert.EqualError(t, err, "no config files")
+}
+
+func TestLoadReportsStraysWithOtherProblems(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"bad.yml": "layout: {github.com: {monopole: [r|]}}\nserverOpts: {gitlab.com: {retries: 3}}\n",
+	})
+	_, err := Load(dir.Append("bad.yml"))
+	var problems Problems
+	if assert.ErrorAs(t, err, &problems) {
+		assert.Len(t, problems, 2)
+		assert.Contains(t, err.Error(), `serverOpts for "gitlab.com", which isn't in the layout`)
+	}
+}
+
+func TestMergePinsBranch(t *testing.T) {
+	a, err := Parse("a.yml", []byte("layout: {github.com: {monopole: [kubectl]}}\n"))
+	assert.NoError(t, err)
+	b, err := Parse("b.yml", []byte("layout: {github.com: {monopole: [kubectl|master]}}\n"))
+	assert.NoError(t, err)
+	c, err := Merge(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []RepoSpec{{Name: "kubectl", Branch: "master"}}, c.Layout["github.com"]["monopole"])
+}
+
+func TestMergeConflicts(t *testing.T) {
+	a, err := Parse("a.yml", []byte(`
+path: here
+layout:
+  github.com:
+    monopole: [kubectl|main]
+serverOpts:
+  github.com:
+    timeout: 80s
+`))
+	assert.NoError(t, err)
+	b, err := Parse("b.yml", []byte(`
+path: there
+layout:
+  github.com:
+    monopole: [kubectl|master]
+serverOpts:
+  github.com:
+    timeout: 10m
+`))
+	assert.NoError(t, err)
+	_, err = Merge(a, b)
+	assert.Equal(t, Problems{
+		{File: "b.yml", Msg: `path is "there" here, but "here" in a.yml`},
+		{File: "b.yml", Msg: `default branch of repo github.com/monopole/kubectl is "master" here, but "main" in a.yml`},
+		{File: "b.yml", Msg: `serverOpts timeout of github.com is "10m" here, but "80s" in a.yml`},
+	}, err)
+}
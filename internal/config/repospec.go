@@ -0,0 +1,116 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/monopole/myrepos/internal/file"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoSpec describes one repo in the layout.
+//
+// In a config file, a repo is either a RepoName string, or a
+// mapping holding some of the fields below, e.g.
+//
+//	monopole:
+//	  - kubectl|master
+//	  - name: mdrip
+//	    branch: master
+//	    dir: mdrip-old
+//	    depth: 1
+//	  - name: monorepo
+//	    filter: blob:none
+//	    sparse: [docs, tools]
+//
+// The string form is short; the mapping form has room for
+// settings that don't fit in a string.
+type RepoSpec struct {
+	// Name is the repo's name on the git server, e.g. kubectl.
+	Name string `yaml:"name"`
+	// Branch pins the repo's default branch.  If empty,
+	// it's the branch the remote's HEAD names.
+	Branch string `yaml:"branch,omitempty"`
+	// Dir names the repo's local directory, if it's not Name.
+	Dir file.Path `yaml:"dir,omitempty"`
+	// Origin is the org of the repo's 'origin' remote,
+	// if it's not the origin of the repo's OrgName.
+	Origin OrgName `yaml:"origin,omitempty"`
+	// Upstream is the org of the repo's 'upstream' remote,
+	// if it's not the upstream of the repo's OrgName.
+	Upstream OrgName `yaml:"upstream,omitempty"`
+	// Skip leaves the repo out of everything, e.g. to keep
+	// it in the config while it's not wanted.
+	Skip bool `yaml:"skip,omitempty"`
+	// NoPush keeps updates of the repo, if it's a fork, from
+	// pushing the default branch to origin.  The repo also
+	// gets its org's NoPush.
+	NoPush bool `yaml:"noPush,omitempty"`
+	// Tags label the repo, e.g. 'work' or 'oss', so it can be
+	// selected as part of a group.  The repo also has its
+	// org's tags.
+	Tags []string `yaml:"tags,omitempty"`
+	// Hooks run in the repo.
+	Hooks `yaml:",inline"`
+	// CloneOpts shape the repo's clone.
+	CloneOpts `yaml:",inline"`
+}
+
+// Spec returns the RepoSpec the RepoName describes.
+func (rn RepoName) Spec() RepoSpec {
+	name, branch := string(rn), ""
+	if k := strings.Index(name, "|"); k >= 0 {
+		name, branch = name[:k], name[k+1:]
+	}
+	return RepoSpec{Name: name, Branch: branch}
+}
+
+// DirName returns the name of the repo's local directory.
+func (rs RepoSpec) DirName() file.Path {
+	if rs.Dir != "" {
+		return rs.Dir
+	}
+	return file.Path(rs.Name)
+}
+
+// BranchOrDefault returns the repo's pinned default
+// branch, or DefaultBranch if it's not pinned.
+func (rs RepoSpec) BranchOrDefault() string {
+	if rs.Branch != "" {
+		return rs.Branch
+	}
+	return DefaultBranch
+}
+
+// isJustName is true if the spec fits in a RepoName.
+func (rs RepoSpec) isJustName() bool {
+	rs.Name, rs.Branch = "", ""
+	return reflect.ValueOf(rs).IsZero()
+}
+
+// plainRepoSpec is a RepoSpec without the yaml methods.
+type plainRepoSpec RepoSpec
+
+// UnmarshalYAML accepts either the string or the mapping form.
+func (rs *RepoSpec) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var s string
+		if err := n.Decode(&s); err != nil {
+			return err
+		}
+		*rs = RepoName(s).Spec()
+		return nil
+	}
+	return n.Decode((*plainRepoSpec)(rs))
+}
+
+// MarshalYAML writes the string form if it suffices.
+func (rs RepoSpec) MarshalYAML() (interface{}, error) {
+	if rs.isJustName() {
+		if rs.Branch == "" {
+			return rs.Name, nil
+		}
+		return rs.Name + "|" + rs.Branch, nil
+	}
+	return plainRepoSpec(rs), nil
+}
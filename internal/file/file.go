@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type Path string
@@ -12,29 +14,76 @@ const defaultConfigFileName = ".myrepos"
 
 var extensions = []string{".yml", ".yaml"}
 
+// EnvConfig names an environment variable holding the path to
+// a config file or directory, to use instead of searching.
+const EnvConfig = "MYREPOS_CONFIG"
+
+// confDir is the subdirectory of a config directory
+// that holds config fragments.
+const confDir = "conf.d"
+
 func DefaultConfigFileName() string {
 	return filepath.Join(string(Home()), defaultConfigFileName+extensions[0])
 }
 
+// xdgConfigDir returns the directory for myrepos config files
+// in the XDG base directory scheme, or "" if there's no HOME.
+func xdgConfigDir() Path {
+	if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+		return Path(d).Append("myrepos")
+	}
+	if home := Home(); home != "" {
+		return home.Append(".config/myrepos")
+	}
+	return ""
+}
+
+// computeDefaultConfigFilePath returns the path named by
+// $MYREPOS_CONFIG, or else the first config found among
+//
+//	./.myrepos.yml
+//	$XDG_CONFIG_HOME/myrepos/   (config.yml, conf.d/*.yml)
+//	$HOME/.myrepos.yml
+//
+// also trying '.yaml' for '.yml'.
 func computeDefaultConfigFilePath() (Path, error) {
-	var badFiles []Path
-	for i := range extensions {
-		p := Path(defaultConfigFileName + extensions[i])
-		if exists, isDir := p.Exists(); exists && !isDir {
-			return p, nil
+	if p := Path(os.Getenv(EnvConfig)); p != "" {
+		if exists, _ := p.Exists(); !exists {
+			return "", fmt.Errorf("no config at %q, named by $%s", p, EnvConfig)
 		}
-		badFiles = append(badFiles, p)
-		if home := Home(); home != "" {
-			p = home.Append(p)
+		return p, nil
+	}
+	var badFiles []Path
+	findFile := func(dir Path) Path {
+		for i := range extensions {
+			p := dir.Append(Path(defaultConfigFileName + extensions[i]))
 			if exists, isDir := p.Exists(); exists && !isDir {
-				return p, nil
+				return p
 			}
 			badFiles = append(badFiles, p)
 		}
+		return ""
+	}
+	if p := findFile(""); p != "" {
+		return p, nil
+	}
+	if dir := xdgConfigDir(); dir != "" {
+		if files, _ := dir.ConfigFiles(); len(files) > 0 {
+			return dir, nil
+		}
+		badFiles = append(badFiles, dir)
+	}
+	if home := Home(); home != "" {
+		if p := findFile(home); p != "" {
+			return p, nil
+		}
 	}
 	return "", fmt.Errorf("unable to open any of these: %v", badFiles)
 }
 
+// GetFilePath returns the config paths named in the args, or the
+// default config path if there are no args.  A path can be a file
+// or a directory; see ConfigFiles.
 func GetFilePath(args []string) ([]Path, error) {
 	if len(args) == 0 {
 		p, err := computeDefaultConfigFilePath()
@@ -43,15 +92,44 @@ func GetFilePath(args []string) ([]Path, error) {
 	var result []Path
 	for i := range args {
 		p := Path(args[i])
-		if exists, isDir := p.Exists(); exists && !isDir {
-			result = append(result, p)
-		} else {
-			if isDir {
-				return nil, fmt.Errorf(
-					"%q found, but it's a directory, not a config file", p)
-			}
+		exists, isDir := p.Exists()
+		if !exists {
 			return nil, fmt.Errorf("no config file found at %q", p)
 		}
+		if isDir {
+			files, err := p.ConfigFiles()
+			if err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("no config files in directory %q", p)
+			}
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// ConfigFiles returns the config files in the directory p, then
+// those in its conf.d subdirectory, each in lexical order.  A
+// directory of config files is treated as one merged config.
+func (p Path) ConfigFiles() ([]Path, error) {
+	var result []Path
+	for _, dir := range []Path{p, p.Append(confDir)} {
+		var names []string
+		for _, ext := range extensions {
+			matches, err := filepath.Glob(filepath.Join(string(dir), "*"+ext))
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, matches...)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			if _, isDir := Path(n).Exists(); !isDir {
+				result = append(result, Path(n))
+			}
+		}
 	}
 	return result, nil
 }
@@ -78,6 +156,30 @@ func (p Path) MkDir() error {
 	return os.MkdirAll(string(p), 0755)
 }
 
+// Expand returns the path with a leading '~' replaced by the
+// home directory, and each $VAR or ${VAR} replaced by the value
+// of the environment variable.  Undefined variables are errors,
+// rather than being replaced by nothing.
+func (p Path) Expand() (Path, error) {
+	s := string(p)
+	if s == "~" || strings.HasPrefix(s, "~/") {
+		s = string(Home()) + s[1:]
+	}
+	var undefined []string
+	s = os.Expand(s, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return v
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf(
+			"undefined variable %s in %q", strings.Join(undefined, ", "), p)
+	}
+	return Path(s), nil
+}
+
 func Home() Path {
 	return Path(os.Getenv("HOME"))
 }
@@ -0,0 +1,99 @@
+package file_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/myrepos/internal/file"
+	"github.com/stretchr/testify/assert"
+)
+
+// chdir changes the working directory until the test ends.
+func chdir(t *testing.T, dir string) {
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(old); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestGetFilePathSearch(t *testing.T) {
+	home, xdg, cwd := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv(EnvConfig, "")
+	chdir(t, cwd)
+	write := func(p string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NoError(t, os.WriteFile(p, []byte("path: x\n"), 0o644))
+	}
+	get := func() Path {
+		paths, err := GetFilePath(nil)
+		assert.NoError(t, err)
+		return paths[0]
+	}
+
+	_, err := GetFilePath(nil)
+	assert.Error(t, err)
+
+	write(filepath.Join(home, ".myrepos.yaml"))
+	assert.Equal(t, Path(filepath.Join(home, ".myrepos.yaml")), get())
+
+	write(filepath.Join(xdg, "myrepos", "conf.d", "work.yml"))
+	assert.Equal(t, Path(filepath.Join(xdg, "myrepos")), get())
+
+	write(filepath.Join(cwd, ".myrepos.yml"))
+	assert.Equal(t, Path(".myrepos.yml"), get())
+
+	t.Setenv(EnvConfig, home)
+	assert.Equal(t, Path(home), get())
+
+	t.Setenv(EnvConfig, filepath.Join(home, "nope"))
+	_, err = GetFilePath(nil)
+	assert.Error(t, err)
+}
+
+func TestGetFilePathDir(t *testing.T) {
+	dir := t.TempDir()
+	_, err := GetFilePath([]string{dir})
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.yml"), nil, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), nil, 0o644))
+	paths, err := GetFilePath([]string{dir})
+	assert.NoError(t, err)
+	assert.Equal(t, []Path{Path(dir)}, paths)
+
+	files, err := Path(dir).ConfigFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []Path{
+		Path(filepath.Join(dir, "a.yaml")), Path(filepath.Join(dir, "b.yml"))}, files)
+}
+
+func TestExpand(t *testing.T) {
+	t.Setenv("HOME", "/home/bob")
+	t.Setenv("ROOT", "/srv")
+	for raw, want := range map[Path]Path{
+		"":              "",
+		"myrepos":       "myrepos",
+		"~":             "/home/bob",
+		"~/src":         "/home/bob/src",
+		"a~/b":          "a~/b",
+		"$HOME/src":     "/home/bob/src",
+		"${ROOT}/repos": "/srv/repos",
+	} {
+		got, err := raw.Expand()
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, "expanding %q", raw)
+	}
+	_, err := Path("$ROOT/${HOME}/$x").Expand()
+	assert.EqualError(t, err, `undefined variable x in "$ROOT/${HOME}/$x"`)
+}
@@ -0,0 +1,233 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monopole/myrepos/internal/config"
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/runner"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+const (
+	gitProgram     = "git"
+	remoteOrigin   = "origin"
+	remoteUpstream = "upstream"
+	gitTimeout     = 10 * time.Second
+)
+
+// Unmapped is a repo that couldn't be described in a config.
+type Unmapped struct {
+	Path   file.Path
+	Reason string
+}
+
+func (u *Unmapped) String() string {
+	return fmt.Sprintf("%s: %s", u.Path, u.Reason)
+}
+
+// clone is what's learned about a local clone.
+type clone struct {
+	path     file.Path
+	origin   *RemoteUrl
+	upstream *RemoteUrl
+	branch   string
+}
+
+// Import looks for git repos below dir, and returns a config
+// with a layout holding those that can be described by one, along
+// with the reasons the others couldn't be.  Nothing is written.
+func Import(ctx context.Context, dir file.Path) (*config.Config, []*Unmapped, error) {
+	repoDirs, err := findRepos(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	gr, err := runner.NewRunner(ctx, gitProgram, gitTimeout, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := newBuilder()
+	for _, p := range repoDirs {
+		c, err := inspect(gr, p)
+		if err != nil {
+			b.unmapped = append(b.unmapped, &Unmapped{Path: p, Reason: err.Error()})
+			continue
+		}
+		b.add(c)
+	}
+	return b.cfg, b.unmapped, nil
+}
+
+// findRepos returns the git repos below dir, not looking
+// inside repos or hidden directories.
+func findRepos(dir file.Path) ([]file.Path, error) {
+	if exists, _ := dir.Append(".git").Exists(); exists {
+		return []file.Path{dir}, nil
+	}
+	entries, err := os.ReadDir(string(dir))
+	if err != nil {
+		return nil, err
+	}
+	var result []file.Path
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		var found []file.Path
+		if found, err = findRepos(dir.Append(file.Path(e.Name()))); err != nil {
+			return nil, err
+		}
+		result = append(result, found...)
+	}
+	return result, nil
+}
+
+// inspect reads the remotes and default branch of a clone.
+func inspect(gr *runner.Runner, p file.Path) (*clone, error) {
+	gr.SetPwd(p)
+	c := &clone{path: p}
+	if err := gr.Run("remote"); err != nil {
+		return nil, err
+	}
+	remotes := strings.Fields(gr.GetOutput())
+	if !contains(remotes, remoteOrigin) {
+		return nil, fmt.Errorf("no %q remote", remoteOrigin)
+	}
+	var err error
+	if c.origin, err = remoteUrl(gr, remoteOrigin); err != nil {
+		return nil, err
+	}
+	c.upstream = c.origin
+	if contains(remotes, remoteUpstream) {
+		if c.upstream, err = remoteUrl(gr, remoteUpstream); err != nil {
+			return nil, err
+		}
+		if c.upstream.Domain != c.origin.Domain {
+			return nil, fmt.Errorf(
+				"origin is on %s but upstream is on %s",
+				c.origin.Domain, c.upstream.Domain)
+		}
+		if c.upstream.Repo != c.origin.Repo {
+			return nil, fmt.Errorf(
+				"origin repo is %q but upstream repo is %q",
+				c.origin.Repo, c.upstream.Repo)
+		}
+	}
+	c.branch = defaultBranch(gr, c.upstream != c.origin)
+	return c, nil
+}
+
+// remoteUrl returns the remote's url as written in the git config,
+// i.e. without any 'insteadOf' rewriting.
+func remoteUrl(gr *runner.Runner, remote string) (*RemoteUrl, error) {
+	if err := gr.Run("config", "--get", "remote."+remote+".url"); err != nil {
+		return nil, err
+	}
+	return ParseUrl(strings.TrimSpace(gr.GetOutput()))
+}
+
+// defaultBranch returns the branch that the remote HEAD points to,
+// as recorded at clone time, falling back to the current branch.
+func defaultBranch(gr *runner.Runner, isAFork bool) string {
+	remotes := []string{remoteOrigin}
+	if isAFork {
+		remotes = []string{remoteUpstream, remoteOrigin}
+	}
+	for _, r := range remotes {
+		if gr.Run("symbolic-ref", "--short", "refs/remotes/"+r+"/HEAD") == nil {
+			return strings.TrimPrefix(strings.TrimSpace(gr.GetOutput()), r+"/")
+		}
+	}
+	if gr.Run("rev-parse", "--abbrev-ref", "HEAD") == nil {
+		if b := strings.TrimSpace(gr.GetOutput()); b != "HEAD" {
+			return b
+		}
+	}
+	return tree.DefaultBranch
+}
+
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// builder accumulates clones into a config.
+type builder struct {
+	cfg      *config.Config
+	unmapped []*Unmapped
+	// seen maps a repo's place in the layout to the first
+	// clone found for it.
+	seen map[string]file.Path
+	// opts maps a domain to the options of the first clone
+	// found on it, even if they're the defaults.
+	opts map[config.ServerDomain]config.ServerOpts
+}
+
+func newBuilder() *builder {
+	return &builder{
+		cfg: &config.Config{
+			Layout:     make(map[config.ServerDomain]map[config.OrgName][]config.RepoSpec),
+			ServerOpts: make(map[config.ServerDomain]config.ServerOpts),
+		},
+		seen: make(map[string]file.Path),
+		opts: make(map[config.ServerDomain]config.ServerOpts),
+	}
+}
+
+func (b *builder) add(c *clone) {
+	d := c.origin.Domain
+	opts := config.ServerOpts{}
+	if c.origin.Scheme == tree.SchemeHttps {
+		opts.Scheme = tree.SchemeHttps.String()
+		opts.Port = c.origin.Port
+	}
+	if c.upstream.Scheme != c.origin.Scheme || c.upstream.Port != c.origin.Port {
+		b.reject(c, "origin and upstream use different schemes or ports")
+		return
+	}
+	if old, ok := b.opts[d]; ok && !reflect.DeepEqual(old, opts) {
+		b.reject(c, fmt.Sprintf(
+			"other repos on %s use a different scheme or port", d))
+		return
+	}
+	orgName := config.OrgName(c.origin.Org)
+	if c.upstream.Org != c.origin.Org {
+		orgName = config.OrgName(
+			c.upstream.Org + "|" + c.origin.Org + "|" + c.upstream.Org)
+	}
+	dirName, _, _ := orgName.Parse()
+	key := string(d) + "/" + string(dirName) + "/" + c.origin.Repo
+	if p, ok := b.seen[key]; ok {
+		b.reject(c, fmt.Sprintf("same place in the layout as %s", p))
+		return
+	}
+	b.seen[key] = c.path
+	b.opts[d] = opts
+	spec := config.RepoSpec{Name: c.origin.Repo}
+	if c.branch != tree.DefaultBranch {
+		spec.Branch = c.branch
+	}
+	if b.cfg.Layout[d] == nil {
+		b.cfg.Layout[d] = make(map[config.OrgName][]config.RepoSpec)
+	}
+	specs := append(b.cfg.Layout[d][orgName], spec)
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	b.cfg.Layout[d][orgName] = specs
+	if !reflect.DeepEqual(opts, config.ServerOpts{}) {
+		b.cfg.ServerOpts[d] = opts
+	}
+}
+
+func (b *builder) reject(c *clone, reason string) {
+	b.unmapped = append(b.unmapped, &Unmapped{Path: c.path, Reason: reason})
+}
@@ -0,0 +1,90 @@
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/monopole/myrepos/internal/config"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// RemoteUrl is a git remote url in the shapes that
+// tree.RepoNode knows how to generate.
+type RemoteUrl struct {
+	Domain config.ServerDomain
+	Scheme tree.Scheme
+	Port   int
+	Org    string
+	Repo   string
+}
+
+// ParseUrl parses a remote url like
+//
+//	git@github.com:monopole/myrepos.git
+//	ssh://git@github.com/monopole/myrepos.git
+//	https://github.com/monopole/myrepos.git
+//	https://gitlab.com/platform/backend/api.git
+//
+// returning an error if the url has some other shape.
+func ParseUrl(raw string) (*RemoteUrl, error) {
+	if strings.HasPrefix(raw, "git@") && !strings.Contains(raw, "://") {
+		k := strings.Index(raw, ":")
+		if k < 0 {
+			return nil, fmt.Errorf("no colon in scp-style url %q", raw)
+		}
+		r := &RemoteUrl{
+			Domain: config.ServerDomain(raw[len("git@"):k]),
+			Scheme: tree.SchemeSsh,
+		}
+		return r, r.setOrgAndRepo(raw, raw[k+1:])
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	r := &RemoteUrl{Domain: config.ServerDomain(u.Hostname())}
+	switch u.Scheme {
+	case "ssh":
+		if u.User.Username() != "git" {
+			return nil, fmt.Errorf("ssh user in %q isn't 'git'", raw)
+		}
+		if u.Port() != "" {
+			return nil, fmt.Errorf("ssh url %q has a port", raw)
+		}
+		r.Scheme = tree.SchemeSsh
+	case "https":
+		if u.User != nil {
+			return nil, fmt.Errorf("https url %q has a user", raw)
+		}
+		r.Scheme = tree.SchemeHttps
+		if p := u.Port(); p != "" {
+			if r.Port, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("bad port in %q", raw)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q in %q", u.Scheme, raw)
+	}
+	return r, r.setOrgAndRepo(raw, u.Path)
+}
+
+func (r *RemoteUrl) setOrgAndRepo(raw, p string) error {
+	if r.Domain == "" {
+		return fmt.Errorf("no domain in %q", raw)
+	}
+	// The org can be a path of nested groups, e.g. on GitLab.
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) < 2 {
+		return fmt.Errorf("path in %q isn't of the form {org}/{repo}", raw)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("path in %q has an empty or dot segment", raw)
+		}
+	}
+	r.Org = strings.Join(parts[:len(parts)-1], "/")
+	r.Repo = strings.TrimSuffix(parts[len(parts)-1], ".git")
+	return nil
+}
@@ -0,0 +1,45 @@
+package importer_test
+
+import (
+	"testing"
+
+	. "github.com/monopole/myrepos/internal/importer"
+	"github.com/monopole/myrepos/internal/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUrl(t *testing.T) {
+	for raw, want := range map[string]*RemoteUrl{
+		"git@github.com:monopole/myrepos.git": {
+			Domain: "github.com", Scheme: tree.SchemeSsh,
+			Org: "monopole", Repo: "myrepos"},
+		"ssh://git@github.com/monopole/myrepos": {
+			Domain: "github.com", Scheme: tree.SchemeSsh,
+			Org: "monopole", Repo: "myrepos"},
+		"https://git.example.com:8443/team/api.git": {
+			Domain: "git.example.com", Scheme: tree.SchemeHttps, Port: 8443,
+			Org: "team", Repo: "api"},
+		"git@gitlab.com:platform/backend/api.git": {
+			Domain: "gitlab.com", Scheme: tree.SchemeSsh,
+			Org: "platform/backend", Repo: "api"},
+	} {
+		got, err := ParseUrl(raw)
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, want, got, raw)
+		}
+	}
+}
+
+func TestParseUrlErrors(t *testing.T) {
+	for _, raw := range []string{
+		"file:///tmp/myrepos.git",
+		"http://github.com/monopole/myrepos.git",
+		"ssh://gitea@git.example.com/team/api.git",
+		"ssh://git@git.example.com:2222/team/api.git",
+		"git@github.com:myrepos.git",
+		"https://gitlab.com/platform//api.git",
+	} {
+		_, err := ParseUrl(raw)
+		assert.Error(t, err, raw)
+	}
+}
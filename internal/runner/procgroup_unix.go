@@ -0,0 +1,20 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup puts the command in its own process group,
+// so that it can be killed along with any children.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the started command and its children.
+func killProcessGroup(cmd *exec.Cmd) {
+	// A negative pid means the process group.
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
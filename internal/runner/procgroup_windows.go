@@ -0,0 +1,16 @@
+//go:build windows
+
+package runner
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(*exec.Cmd) {
+	// TODO: use a job object to capture children.
+}
+
+// killProcessGroup kills the started command, but not its children.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -1,15 +1,21 @@
 package runner
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/monopole/myrepos/internal/file"
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/monopole/myrepos/internal/file"
 )
 
 // Runner runs some program (it wraps exec).
 type Runner struct {
+	// ctx cancels every run, e.g. on SIGINT.
+	ctx context.Context
 	// programPath is the program to run.
 	programPath string
 	// workDir is the working directory of the subprocess.
@@ -20,10 +26,33 @@ type Runner struct {
 	output []byte
 	// errAbbrevs maps error substrings to short, one-liner errors.
 	errAbbrevs map[string]string
+	// retry says how to retry transient failures.
+	retry Retry
+	// retries counts the retries done by all runs so far.
+	retries int
+}
+
+// Retry says how to retry runs that fail for transient reasons.
+type Retry struct {
+	// Count is the maximum number of retries of one run.
+	Count int
+	// Delay is how long to wait before the first retry.
+	// It doubles before each subsequent retry.
+	Delay time.Duration
+	// Transient holds substrings of errors or output that mark a
+	// failure as transient.  Timeouts are transient too.
+	Transient []string
+	// Retryable, if not nil, says whether a run with the given
+	// arguments may be retried at all.  A command that changes
+	// local state, e.g. one killed by a timeout part way through,
+	// might not be safe to run again.
+	Retryable func(args []string) bool
 }
 
 // NewRunner returns a Runner if it can find the program.
+// Canceling the context kills any program the Runner is running.
 func NewRunner(
+	ctx context.Context,
 	programShortName string,
 	timeout time.Duration,
 	errAbbrevs map[string]string) (*Runner, error) {
@@ -33,6 +62,7 @@ func NewRunner(
 			"no executable named %q on path: %w", programShortName, err)
 	}
 	return &Runner{
+		ctx:         ctx,
 		programPath: p,
 		duration:    timeout,
 		errAbbrevs:  errAbbrevs,
@@ -44,6 +74,16 @@ func (r *Runner) SetPwd(d file.Path) {
 	r.workDir = string(d)
 }
 
+// SetRetry changes how transient failures are retried.
+func (r *Runner) SetRetry(rt Retry) {
+	r.retry = rt
+}
+
+// Retries returns the number of retries done by all runs so far.
+func (r *Runner) Retries() int {
+	return r.retries
+}
+
 // GetOutput returns the combined stdout, stderr output of the most recent run.
 func (r *Runner) GetOutput() string {
 	return string(r.output)
@@ -58,47 +98,93 @@ func (r *Runner) commonError(err error) (string, bool) {
 	return "", false
 }
 
-// Run a command in the dir with a timeout.
+func (r *Runner) isTransient(err error, args []string) bool {
+	if r.ctx.Err() != nil {
+		return false
+	}
+	if r.retry.Retryable != nil && !r.retry.Retryable(args) {
+		return false
+	}
+	if IsErrTimeout(err) {
+		return true
+	}
+	for _, t := range r.retry.Transient {
+		if strings.Contains(err.Error(), t) || strings.Contains(r.GetOutput(), t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run a command in the dir with a timeout, retrying it
+// with exponential backoff if it fails for a transient reason.
 func (r *Runner) Run(args ...string) error {
+	err := r.runOnce(args...)
+	delay := r.retry.Delay
+	for i := 0; i < r.retry.Count && err != nil && r.isTransient(err, args); i++ {
+		select {
+		case <-r.ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		r.retries++
+		err = r.runOnce(args...)
+	}
+	return err
+}
+
+// runOnce runs a command in the dir with a timeout.
+//
+// The command runs in its own process group.  If the timeout
+// expires, or the Runner's context is canceled, the whole group
+// is killed, so nothing started by the command outlives the call.
+func (r *Runner) runOnce(args ...string) error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.duration)
+	defer cancel()
 	//nolint: gosec
-	cmd := exec.Command(r.programPath, args...)
+	cmd := exec.CommandContext(ctx, r.programPath, args...)
 	if r.workDir != "" {
 		cmd.Dir = r.workDir
 	}
-	var err error
-	return TimedCall(
-		cmd.String(),
-		r.duration,
-		func() error {
-			r.output, err = cmd.CombinedOutput()
-			if err != nil {
-				if msg, isCommon := r.commonError(err); isCommon {
-					return fmt.Errorf(
-						"%s (was running: %s)", msg, cmd.String())
-				}
-				if cmd.Dir == "" {
-					return fmt.Errorf("%w (was running %q)\n    %s",
-						err, cmd.String(), r.GetOutput())
-				}
-				return fmt.Errorf(
-					"%w (was running %q in dir %q)\n    %s",
-					err, cmd.String(), cmd.Dir, r.GetOutput())
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	setProcessGroup(cmd)
+	err := cmd.Start()
+	if err == nil {
+		// CommandContext only kills the direct child, and
+		// grandchildren holding the output pipe open would
+		// keep Wait from returning.
+		waited := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-waited:
 			}
-			return err
-		})
-}
-
-// TimedCall runs fn, failing if it doesn't complete in the given duration.
-// The description is used in the timeout error message.
-func TimedCall(description string, d time.Duration, fn func() error) error {
-	done := make(chan error)
-	timer := time.NewTimer(d)
-	defer timer.Stop()
-	go func() { done <- fn() }()
-	select {
-	case err := <-done:
-		return err
-	case <-timer.C:
-		return NewErrTimeOut(d, description)
+		}()
+		err = cmd.Wait()
+		close(waited)
+	}
+	r.output = out.Bytes()
+	if err == nil {
+		return nil
+	}
+	if r.ctx.Err() != nil {
+		return fmt.Errorf("%w (was running %q)", r.ctx.Err(), cmd.String())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return NewErrTimeOut(r.duration, cmd.String())
+	}
+	if msg, isCommon := r.commonError(err); isCommon {
+		return fmt.Errorf(
+			"%s (was running: %s)", msg, cmd.String())
+	}
+	if cmd.Dir == "" {
+		return fmt.Errorf("%w (was running %q)\n    %s",
+			err, cmd.String(), r.GetOutput())
 	}
+	return fmt.Errorf(
+		"%w (was running %q in dir %q)\n    %s",
+		err, cmd.String(), cmd.Dir, r.GetOutput())
 }
@@ -0,0 +1,80 @@
+//go:build linux
+
+package runner_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monopole/myrepos/internal/file"
+	. "github.com/monopole/myrepos/internal/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunTimeoutKillsProcessGroup(t *testing.T) {
+	r, err := NewRunner(context.Background(), "sh", 200*time.Millisecond, nil)
+	assert.NoError(t, err)
+	start := time.Now()
+	// The backgrounded sleep holds the output pipe open, so Run
+	// only returns early if the whole process group is killed.
+	err = r.Run("-c", "sleep 10 & sleep 10")
+	assert.True(t, IsErrTimeout(err), "got %v", err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := NewRunner(ctx, "sleep", time.Minute, nil)
+	assert.NoError(t, err)
+	time.AfterFunc(200*time.Millisecond, cancel)
+	err = r.Run("10")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, IsErrTimeout(err))
+}
+
+func TestRunOutput(t *testing.T) {
+	r, err := NewRunner(context.Background(), "sh", time.Minute, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Run("-c", "echo hello; echo there >&2"))
+	assert.Equal(t, "hello\nthere\n", r.GetOutput())
+}
+
+func TestRunRetriesTransientFailures(t *testing.T) {
+	r, err := NewRunner(context.Background(), "sh", time.Minute, nil)
+	assert.NoError(t, err)
+	r.SetRetry(Retry{
+		Count:     3,
+		Delay:     time.Millisecond,
+		Transient: []string{"Connection refused"},
+	})
+	r.SetPwd(file.Path(t.TempDir()))
+	// Fail twice, then succeed.
+	script := `echo x >> tries; [ $(wc -l < tries) -gt 2 ] || { echo "Connection refused"; exit 1; }`
+	assert.NoError(t, r.Run("-c", script))
+	assert.Equal(t, 2, r.Retries())
+
+	// Don't retry other failures.
+	assert.Error(t, r.Run("-c", "echo nope; exit 1"))
+	assert.Equal(t, 2, r.Retries())
+}
+
+func TestRunRetriesOnlyRetryableCommands(t *testing.T) {
+	r, err := NewRunner(context.Background(), "sh", 100*time.Millisecond, nil)
+	assert.NoError(t, err)
+	r.SetRetry(Retry{
+		Count: 2,
+		Delay: time.Millisecond,
+		Retryable: func(args []string) bool {
+			return strings.HasSuffix(args[1], "# retryable")
+		},
+	})
+	err = r.Run("-c", "sleep 1")
+	assert.True(t, IsErrTimeout(err), "got %v", err)
+	assert.Equal(t, 0, r.Retries())
+
+	err = r.Run("-c", "sleep 1 # retryable")
+	assert.True(t, IsErrTimeout(err), "got %v", err)
+	assert.Equal(t, 2, r.Retries())
+}
@@ -3,6 +3,7 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -15,7 +16,7 @@ const (
 )
 
 func errIfNoSshAgent() error {
-	r, err := runner.NewRunner("ps", 2*time.Second, nil)
+	r, err := runner.NewRunner(context.Background(), "ps", 2*time.Second, nil)
 	if err != nil {
 		return err
 	}
@@ -29,7 +30,7 @@ func errIfNoSshAgent() error {
 }
 
 func errIfNoSshKeys() error {
-	r, err := runner.NewRunner("ssh-add", 2*time.Second, nil)
+	r, err := runner.NewRunner(context.Background(), "ssh-add", 2*time.Second, nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,141 @@
+package tree
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter selects repos using glob patterns, as understood by
+// path.Match, matched against a repo's server domain, org
+// directory and repo name, e.g.
+//
+//	github.com/monopole/*
+//
+// A pattern with fewer parts than the repo's path is matched
+// against the trailing parts, so '*/kustomize' selects repos named
+// kustomize in any org, and 'kustomize' does the same.
+//
+// An org with nested groups, e.g. platform/backend, has one path
+// part per group.  A '**' part matches any number of parts, so
+// 'gitlab.com/**' selects all the repos on gitlab.com, and
+// 'platform/**' all the repos below the platform group.
+// Without nested groups, 'github.com/*/*' does the same as
+// 'github.com/**'.
+//
+// A Filter can also select repos by their tags.
+type Filter struct {
+	// Only, if not empty, keeps just the repos matching some pattern.
+	Only []string
+	// Skip drops the repos matching any pattern.
+	Skip []string
+	// Groups, if not empty, keeps just the repos having some tag.
+	Groups []string
+}
+
+// Validate returns an error if any pattern is malformed.
+func (f *Filter) Validate() error {
+	for _, list := range [][]string{f.Only, f.Skip} {
+		for _, p := range list {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("bad repo pattern %q; %w", p, err)
+			}
+		}
+	}
+	for _, g := range f.Groups {
+		if g == "" {
+			return fmt.Errorf("empty group name")
+		}
+	}
+	return nil
+}
+
+// IsEmpty is true if the filter keeps everything.
+func (f *Filter) IsEmpty() bool {
+	return len(f.Only) == 0 && len(f.Skip) == 0 && len(f.Groups) == 0
+}
+
+// Keep is true if the filter selects the repo.
+func (f *Filter) Keep(n *RepoNode) bool {
+	if len(f.Groups) > 0 && !n.HasTag(f.Groups...) {
+		return false
+	}
+	parts := n.pathParts()
+	if len(f.Only) > 0 && !matchesAny(f.Only, parts) {
+		return false
+	}
+	return !matchesAny(f.Skip, parts)
+}
+
+func matchesAny(patterns []string, parts []string) bool {
+	for _, p := range patterns {
+		if matches(strings.Split(p, "/"), parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches is true if the pattern parts match the trailing path parts.
+func matches(pattern []string, parts []string) bool {
+	for k := 0; k < len(parts); k++ {
+		if matchesAll(pattern, parts[k:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAll is true if the pattern parts match all the path parts.
+func matchesAll(pattern []string, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for k := 0; k <= len(parts); k++ {
+			if matchesAll(pattern[1:], parts[k:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchesAll(pattern[1:], parts[1:])
+}
+
+// pathParts returns the repo's server domain, the groups of
+// its org directory and its name.
+func (n *RepoNode) pathParts() []string {
+	parts := []string{string(n.parent.parent.domain)}
+	parts = append(parts, strings.Split(string(n.parent.nameDir), "/")...)
+	return append(parts, n.Name)
+}
+
+// Prune removes the repos that keep rejects, along with
+// any orgs and servers left with no repos.
+func (n *RootNode) Prune(keep func(*RepoNode) bool) {
+	var servers []*ServerNode
+	for _, sn := range n.children {
+		var orgs []*OrgNode
+		for _, on := range sn.children {
+			var repos []*RepoNode
+			for _, rn := range on.children {
+				if keep(rn) {
+					repos = append(repos, rn)
+				}
+			}
+			if on.children = repos; len(repos) > 0 {
+				orgs = append(orgs, on)
+			}
+		}
+		if sn.children = orgs; len(orgs) > 0 {
+			servers = append(servers, sn)
+		}
+	}
+	n.children = servers
+}
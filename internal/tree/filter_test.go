@@ -0,0 +1,113 @@
+package tree_test
+
+import (
+	"testing"
+
+	"github.com/monopole/myrepos/internal/config"
+	. "github.com/monopole/myrepos/internal/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+// repoLister collects "{domain}/{orgDir}/{repo}" for each repo.
+type repoLister struct {
+	domain config.ServerDomain
+	org    string
+	repos  []string
+}
+
+func (v *repoLister) VisitRootNode(*RootNode)       {}
+func (v *repoLister) VisitServerNode(n *ServerNode) { v.domain = n.Domain() }
+func (v *repoLister) VisitOrgNode(n *OrgNode)       { v.org = string(n.NameDir()) }
+func (v *repoLister) VisitRepoNode(n *RepoNode) {
+	v.repos = append(v.repos, string(v.domain)+"/"+v.org+"/"+n.Name)
+}
+
+func TestFilterPrune(t *testing.T) {
+	c := &config.Config{
+		Path: "/tmp",
+		Layout: map[config.ServerDomain]map[config.OrgName][]config.RepoSpec{
+			"github.com": {
+				"monopole":                             {{Name: "mdrip"}, {Name: "myrepos", Tags: []string{"tools"}}},
+				"sigs.k8s.io|monopole|kubernetes-sigs": {{Name: "kustomize"}},
+			},
+			"gitlab.com": {
+				"kustomize":        {{Name: "kustomize"}, {Name: "docs"}},
+				"platform/backend": {{Name: "api"}},
+			},
+		},
+		OrgOpts: map[config.ServerDomain]map[config.OrgName]config.OrgOpts{
+			"gitlab.com": {"kustomize": {Tags: []string{"work"}}},
+		},
+	}
+	for name, tc := range map[string]struct {
+		filter Filter
+		want   []string
+	}{
+		"empty": {
+			want: []string{
+				"github.com/monopole/mdrip",
+				"github.com/monopole/myrepos",
+				"github.com/sigs.k8s.io/kustomize",
+				"gitlab.com/kustomize/docs",
+				"gitlab.com/kustomize/kustomize",
+				"gitlab.com/platform/backend/api",
+			},
+		},
+		"onlyOrg": {
+			filter: Filter{Only: []string{"github.com/monopole/*"}},
+			want: []string{
+				"github.com/monopole/mdrip",
+				"github.com/monopole/myrepos",
+			},
+		},
+		"onlyRepoName": {
+			filter: Filter{Only: []string{"*/kustomize"}},
+			want: []string{
+				"github.com/sigs.k8s.io/kustomize",
+				"gitlab.com/kustomize/kustomize",
+			},
+		},
+		"skipServer": {
+			filter: Filter{Skip: []string{"gitlab.com/**"}},
+			want: []string{
+				"github.com/monopole/mdrip",
+				"github.com/monopole/myrepos",
+				"github.com/sigs.k8s.io/kustomize",
+			},
+		},
+		"nestedGroup": {
+			filter: Filter{Only: []string{"platform/*/*"}},
+			want:   []string{"gitlab.com/platform/backend/api"},
+		},
+		"doubleStar": {
+			filter: Filter{Only: []string{"gitlab.com/**"}, Skip: []string{"kustomize/*"}},
+			want:   []string{"gitlab.com/platform/backend/api"},
+		},
+		"group": {
+			filter: Filter{Groups: []string{"tools", "work"}},
+			want: []string{
+				"github.com/monopole/myrepos",
+				"gitlab.com/kustomize/docs",
+				"gitlab.com/kustomize/kustomize",
+			},
+		},
+		"groupAndSkip": {
+			filter: Filter{Groups: []string{"work"}, Skip: []string{"docs"}},
+			want:   []string{"gitlab.com/kustomize/kustomize"},
+		},
+		"onlyAndSkip": {
+			filter: Filter{Only: []string{"m*"}, Skip: []string{"*/mdrip"}},
+			want:   []string{"github.com/monopole/myrepos"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, tc.filter.Validate())
+			rn, err := MakeRootNode(c)
+			assert.NoError(t, err)
+			rn.Prune(tc.filter.Keep)
+			var v repoLister
+			rn.Accept(&v)
+			assert.Equal(t, tc.want, v.repos)
+		})
+	}
+}
@@ -11,7 +11,13 @@ type OrgNode struct {
 	nameDir      file.Path
 	nameOrigin   string
 	nameUpstream string
+	tags         []string
+	hooks        config.Hooks
+	noPush       bool
 	children     []*RepoNode
+	// skipped holds the repos the config marks skip, which
+	// only a SkippedRepoVisitor sees.
+	skipped []*RepoNode
 }
 
 func (n *OrgNode) Accept(v Visitor) {
@@ -19,6 +25,15 @@ func (n *OrgNode) Accept(v Visitor) {
 	for _, c := range n.children {
 		c.Accept(v)
 	}
+	if sv, ok := v.(SkippedRepoVisitor); ok {
+		for _, c := range n.skipped {
+			sv.VisitSkippedRepoNode(c)
+		}
+	}
+}
+
+func (n *OrgNode) Server() *ServerNode {
+	return n.parent
 }
 
 func (n *OrgNode) ServerSpec() *ServerSpec {
@@ -37,28 +52,42 @@ func (n *OrgNode) NameUpstream() string {
 	return n.nameUpstream
 }
 
+// Tags returns the org's tags, which its repos inherit.
+func (n *OrgNode) Tags() []string {
+	return n.tags
+}
+
 func (n *OrgNode) AbsPath() file.Path {
 	return n.parent.AbsPath().Append(n.nameDir)
 }
 
-func MakeOrgNode(p *ServerNode, orgName config.OrgName, names []config.RepoName) (on *OrgNode, err error) {
+func MakeOrgNode(
+	p *ServerNode, orgName config.OrgName,
+	specs []config.RepoSpec, opts config.OrgOpts) (on *OrgNode, err error) {
 	dirName, origin, upstream := orgName.Parse()
 	on = &OrgNode{
 		parent:       p,
 		nameDir:      dirName,
 		nameOrigin:   string(origin),
 		nameUpstream: string(upstream),
+		tags:         sortedTags(opts.Tags),
+		hooks:        opts.Hooks,
+		noPush:       opts.NoPush,
 	}
-	for _, repoName := range names {
+	for _, spec := range specs {
 		var rn *RepoNode
-		rn, err = MakeRepoNode(on, repoName)
+		rn, err = MakeRepoNode(on, spec)
 		if err != nil {
 			return nil, err
 		}
+		if spec.Skip {
+			on.skipped = append(on.skipped, rn)
+			continue
+		}
 		on.children = append(on.children, rn)
 	}
 	sort.Slice(on.children, func(i, j int) bool {
-		return on.children[i].Name < on.children[j].Name
+		return on.children[i].nameDir < on.children[j].nameDir
 	})
 	return
 }
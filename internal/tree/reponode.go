@@ -4,23 +4,107 @@ import (
 	"github.com/monopole/myrepos/internal/config"
 	"github.com/monopole/myrepos/internal/file"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-const DefaultBranch = "main"
+const DefaultBranch = config.DefaultBranch
 
 type RepoNode struct {
-	parent        *OrgNode
-	Name          string
+	parent *OrgNode
+	Name   string
+	// DefaultBranch is the pinned default branch, or, if the
+	// config doesn't pin one, DefaultBranch until the remote
+	// says otherwise.
 	DefaultBranch string
+	// branchPinned is true if the config pins DefaultBranch.
+	branchPinned bool
+	// Clone holds the options for cloning the repo,
+	// with its server's options filling in unset ones.
+	Clone        config.CloneOpts
+	nameDir      file.Path
+	nameOrigin   string
+	nameUpstream string
+	tags         []string
+	hooks        config.Hooks
+	noPush       bool
 }
 
 func (n *RepoNode) Accept(v Visitor) {
 	v.VisitRepoNode(n)
 }
 
+func (n *RepoNode) Org() *OrgNode {
+	return n.parent
+}
+
+func (n *RepoNode) NameDir() file.Path {
+	return n.nameDir
+}
+
+func (n *RepoNode) NameOrigin() string {
+	return n.nameOrigin
+}
+
+func (n *RepoNode) NameUpstream() string {
+	return n.nameUpstream
+}
+
+// BranchPinned is true if the config names the default branch,
+// rather than leaving it to the remote.
+func (n *RepoNode) BranchPinned() bool {
+	return n.branchPinned
+}
+
+// NoPush is true if updating the repo, if it's a fork,
+// shouldn't push the default branch to origin.
+func (n *RepoNode) NoPush() bool {
+	return n.noPush
+}
+
+// Tags returns the repo's tags, including its org's tags.
+func (n *RepoNode) Tags() []string {
+	return n.tags
+}
+
+// HasTag is true if the repo has any of the given tags.
+func (n *RepoNode) HasTag(tags ...string) bool {
+	for _, t := range tags {
+		for _, u := range n.tags {
+			if t == u {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PostClone returns the commands to run after cloning the repo.
+func (n *RepoNode) PostClone() []string {
+	return n.allHooks(func(h config.Hooks) string { return h.PostClone })
+}
+
+// PostUpdate returns the commands to run after rebasing the repo.
+func (n *RepoNode) PostUpdate() []string {
+	return n.allHooks(func(h config.Hooks) string { return h.PostUpdate })
+}
+
+// allHooks returns one hook from each of the server's, org's
+// and repo's hooks, in that order, skipping empty ones.
+func (n *RepoNode) allHooks(get func(config.Hooks) string) []string {
+	var result []string
+	for _, h := range []config.Hooks{
+		n.ServerSpec().Hooks(), n.parent.hooks, n.hooks} {
+		if cmd := get(h); cmd != "" {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
 func (n *RepoNode) AbsPath() file.Path {
-	return n.AbsParent().Append(file.Path(n.Name))
+	return n.AbsParent().Append(n.nameDir)
 }
 
 func (n *RepoNode) AbsParent() file.Path {
@@ -32,14 +116,22 @@ func (n *RepoNode) ServerSpec() *ServerSpec {
 }
 
 func (n *RepoNode) UrlOrigin() string {
-	return n.urlSpec(n.parent.nameOrigin)
+	return n.urlSpec(n.nameOrigin)
 }
 
 func (n *RepoNode) UrlUpstream() string {
-	return n.urlSpec(n.parent.nameUpstream)
+	return n.urlSpec(n.nameUpstream)
 }
 
 func (n *RepoNode) urlSpec(o string) string {
+	if t := n.ServerSpec().UrlTemplate(); t != "" {
+		return strings.NewReplacer(
+			"{domain}", string(n.parent.parent.Domain()),
+			"{port}", strconv.Itoa(n.ServerSpec().Port()),
+			"{org}", o,
+			"{repo}", n.Name,
+		).Replace(t)
+	}
 	p := path.Join(o, n.Name) + ".git"
 	if n.ServerSpec().Scheme() == SchemeHttps {
 		// https://github.com/monopole/myrepos.git
@@ -50,20 +142,49 @@ func (n *RepoNode) urlSpec(o string) string {
 }
 
 func (n *RepoNode) IsAFork() bool {
-	return n.parent.nameOrigin != n.parent.nameUpstream
+	return n.nameOrigin != n.nameUpstream
 }
 
-func MakeRepoNode(p *OrgNode, name config.RepoName) (n *RepoNode, err error) {
-	defaultBranch := DefaultBranch
-	repoName := string(name)
-	// Allow user to specify a default branch name after a pipe.
-	if k := strings.Index(repoName, "|"); k >= 0 {
-		defaultBranch = repoName[k+1:]
-		repoName = repoName[:k]
+// sortedTags returns the tags in the lists sorted, without duplicates.
+func sortedTags(lists ...[]string) []string {
+	var result []string
+	for _, tags := range lists {
+		result = append(result, tags...)
+	}
+	if len(result) == 0 {
+		return nil
 	}
-	return &RepoNode{
+	sort.Strings(result)
+	k := 0
+	for i := range result {
+		if i == 0 || result[i] != result[k-1] {
+			result[k] = result[i]
+			k++
+		}
+	}
+	return result[:k]
+}
+
+func MakeRepoNode(p *OrgNode, spec config.RepoSpec) (n *RepoNode, err error) {
+	n = &RepoNode{
 		parent:        p,
-		Name:          repoName,
-		DefaultBranch: defaultBranch,
-	}, nil
+		Name:          spec.Name,
+		DefaultBranch: spec.BranchOrDefault(),
+		branchPinned:  spec.Branch != "",
+		Clone:         spec.CloneOpts.Over(p.ServerSpec().CloneOpts()),
+		nameDir:       spec.DirName(),
+		nameOrigin:    p.nameOrigin,
+		nameUpstream:  p.nameUpstream,
+		tags:          sortedTags(spec.Tags, p.tags),
+		hooks:         spec.Hooks,
+		noPush:        spec.NoPush || p.noPush,
+	}
+	// The repo may override its org's remotes.
+	if spec.Origin != "" {
+		n.nameOrigin = string(spec.Origin)
+	}
+	if spec.Upstream != "" {
+		n.nameUpstream = string(spec.Upstream)
+	}
+	return n, nil
 }
@@ -0,0 +1,109 @@
+package tree_test
+
+import (
+	"testing"
+
+	"github.com/monopole/myrepos/internal/config"
+	. "github.com/monopole/myrepos/internal/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+// repoGetter remembers the last repo visited.
+type repoGetter struct {
+	repo *RepoNode
+}
+
+func (v *repoGetter) VisitRootNode(*RootNode)     {}
+func (v *repoGetter) VisitServerNode(*ServerNode) {}
+func (v *repoGetter) VisitOrgNode(*OrgNode)       {}
+func (v *repoGetter) VisitRepoNode(n *RepoNode)   { v.repo = n }
+
+func TestRepoNodeInheritsFromOrgAndServer(t *testing.T) {
+	rn, err := MakeRootNode(&config.Config{
+		Path: "/tmp",
+		Layout: map[config.ServerDomain]map[config.OrgName][]config.RepoSpec{
+			"github.com": {"monopole|monopole": {{
+				Name:      "mdrip",
+				Tags:      []string{"tools", "oss"},
+				Hooks:     config.Hooks{PostClone: "make tools"},
+				CloneOpts: config.CloneOpts{Depth: 1, Sparse: []string{"docs"}},
+			}}},
+		},
+		ServerOpts: map[config.ServerDomain]config.ServerOpts{
+			"github.com": {
+				Hooks: config.Hooks{
+					PostClone:  "pre-commit install",
+					PostUpdate: "go mod download",
+				},
+				CloneOpts: config.CloneOpts{Depth: 50, Filter: "blob:none"},
+			},
+		},
+		OrgOpts: map[config.ServerDomain]map[config.OrgName]config.OrgOpts{
+			"github.com": {"monopole": {Tags: []string{"oss"}, NoPush: true}},
+		},
+	})
+	assert.NoError(t, err)
+	var v repoGetter
+	rn.Accept(&v)
+	assert.Equal(t, []string{"oss", "tools"}, v.repo.Tags())
+	assert.Equal(t, []string{"pre-commit install", "make tools"}, v.repo.PostClone())
+	assert.Equal(t, []string{"go mod download"}, v.repo.PostUpdate())
+	assert.True(t, v.repo.NoPush())
+	assert.Equal(t, config.CloneOpts{
+		Depth: 1, Filter: "blob:none", Sparse: []string{"docs"}}, v.repo.Clone)
+}
+
+func TestRepoNodeUrls(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts             config.ServerOpts
+		origin, upstream string
+	}{
+		"ssh": {
+			origin:   "git@example.com:monopole/mdrip.git",
+			upstream: "git@example.com:kubernetes-sigs/mdrip.git",
+		},
+		"https": {
+			opts:     config.ServerOpts{Scheme: "https", Port: 8443},
+			origin:   "https://example.com:8443/monopole/mdrip.git",
+			upstream: "https://example.com:8443/kubernetes-sigs/mdrip.git",
+		},
+		"template": {
+			opts: config.ServerOpts{
+				Port:        2222,
+				UrlTemplate: "ssh://gitea@{domain}:{port}/git/{org}/{repo}",
+			},
+			origin:   "ssh://gitea@example.com:2222/git/monopole/mdrip",
+			upstream: "ssh://gitea@example.com:2222/git/kubernetes-sigs/mdrip",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rn, err := MakeRootNode(&config.Config{
+				Path: "/tmp",
+				Layout: map[config.ServerDomain]map[config.OrgName][]config.RepoSpec{
+					"example.com": {"k|monopole|kubernetes-sigs": {{Name: "mdrip"}}},
+				},
+				ServerOpts: map[config.ServerDomain]config.ServerOpts{"example.com": tc.opts},
+			})
+			assert.NoError(t, err)
+			var v repoGetter
+			rn.Accept(&v)
+			assert.Equal(t, tc.origin, v.repo.UrlOrigin())
+			assert.Equal(t, tc.upstream, v.repo.UrlUpstream())
+		})
+	}
+}
+
+func TestRepoNodeNestedGroups(t *testing.T) {
+	rn, err := MakeRootNode(&config.Config{
+		Path: "/tmp",
+		Layout: map[config.ServerDomain]map[config.OrgName][]config.RepoSpec{
+			"gitlab.com": {"platform/backend|jane/forks|platform/backend": {{Name: "api"}}},
+		},
+	})
+	assert.NoError(t, err)
+	var v repoGetter
+	rn.Accept(&v)
+	assert.Equal(t, "/tmp/gitlab.com/platform/backend/api", string(v.repo.AbsPath()))
+	assert.Equal(t, "git@gitlab.com:jane/forks/api.git", v.repo.UrlOrigin())
+	assert.Equal(t, "git@gitlab.com:platform/backend/api.git", v.repo.UrlUpstream())
+}
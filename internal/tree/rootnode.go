@@ -1,12 +1,15 @@
 package tree
 
 import (
+	"fmt"
 	"github.com/monopole/myrepos/internal/config"
 	"github.com/monopole/myrepos/internal/file"
 	"sort"
 )
 
 type RootNode struct {
+	// rawPath is the root path as written in the config.
+	rawPath  file.Path
 	absPath  file.Path
 	children []*ServerNode
 }
@@ -22,9 +25,17 @@ func (n *RootNode) AbsPath() file.Path {
 	return n.absPath
 }
 
+// RawPath returns the root path as written in the config,
+// before expanding variables.
+func (n *RootNode) RawPath() file.Path {
+	return n.rawPath
+}
+
 func MakeRootNode(c *config.Config) (rn *RootNode, err error) {
-	rn = &RootNode{
-		absPath: absRootDir(c),
+	rn = &RootNode{rawPath: c.Path}
+	rn.absPath, err = absRootDir(c)
+	if err != nil {
+		return nil, err
 	}
 	serverSpecs := make(map[config.ServerDomain]*ServerSpec)
 	for d, opts := range c.ServerOpts {
@@ -38,7 +49,7 @@ func MakeRootNode(c *config.Config) (rn *RootNode, err error) {
 			serverSpecs[domain] = MakeServerSpec()
 		}
 		var sn *ServerNode
-		sn, err = MakeServerNode(rn, domain, serverSpecs[domain], orgMap)
+		sn, err = MakeServerNode(rn, domain, serverSpecs[domain], orgMap, c.OrgOpts[domain])
 		if err != nil {
 			return nil, err
 		}
@@ -50,12 +61,16 @@ func MakeRootNode(c *config.Config) (rn *RootNode, err error) {
 	return rn, nil
 }
 
-func absRootDir(c *config.Config) file.Path {
-	if c.Path == "" {
-		return file.Home()
+func absRootDir(c *config.Config) (file.Path, error) {
+	p, err := c.Path.Expand()
+	if err != nil {
+		return "", fmt.Errorf("bad root path: %w", err)
+	}
+	if p == "" {
+		return file.Home(), nil
 	}
-	if c.Path.IsAbs() {
-		return c.Path
+	if p.IsAbs() {
+		return p, nil
 	}
-	return file.Home().Append(c.Path)
+	return file.Home().Append(p), nil
 }
@@ -34,7 +34,8 @@ func (n *ServerNode) Domain() config.ServerDomain {
 
 func MakeServerNode(
 	p *RootNode, n config.ServerDomain,
-	spec *ServerSpec, orgMap map[config.OrgName][]config.RepoName) (sn *ServerNode, err error) {
+	spec *ServerSpec, orgMap map[config.OrgName][]config.RepoSpec,
+	orgOpts map[config.OrgName]config.OrgOpts) (sn *ServerNode, err error) {
 	sn = &ServerNode{
 		parent: p,
 		spec:   spec,
@@ -42,7 +43,7 @@ func MakeServerNode(
 	}
 	for orgName, repoList := range orgMap {
 		var on *OrgNode
-		on, err = MakeOrgNode(sn, orgName, repoList)
+		on, err = MakeOrgNode(sn, orgName, repoList, optsFor(orgName, orgOpts))
 		if err != nil {
 			return nil, err
 		}
@@ -53,3 +54,23 @@ func MakeServerNode(
 	})
 	return
 }
+
+// optsFor combines the options of all the orgs having
+// the same directory as the given org.
+func optsFor(
+	orgName config.OrgName, orgOpts map[config.OrgName]config.OrgOpts) (result config.OrgOpts) {
+	dir, _, _ := orgName.Parse()
+	for name, opts := range orgOpts {
+		if d, _, _ := name.Parse(); d == dir {
+			result.Tags = append(result.Tags, opts.Tags...)
+			result.NoPush = result.NoPush || opts.NoPush
+			if opts.PostClone != "" {
+				result.PostClone = opts.PostClone
+			}
+			if opts.PostUpdate != "" {
+				result.PostUpdate = opts.PostUpdate
+			}
+		}
+	}
+	return
+}
@@ -2,20 +2,27 @@ package tree
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/monopole/myrepos/internal/config"
 )
 
 type ServerSpec struct {
-	port    int
-	scheme  Scheme
-	timeout time.Duration
+	port       int
+	scheme     Scheme
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+	hooks      config.Hooks
+	clone      config.CloneOpts
+	// urlTemplate, if not empty, makes repo urls.
+	urlTemplate string
 }
 
 func (s *ServerSpec) String() string {
-	return fmt.Sprintf("schema=%s port=%d timeout=%s",
-		s.scheme.String(), s.port, s.Timeout())
+	return fmt.Sprintf("schema=%s port=%d timeout=%s retries=%d retryDelay=%s",
+		s.scheme.String(), s.port, s.Timeout(), s.retries, s.retryDelay)
 }
 
 func (s *ServerSpec) Timeout() time.Duration {
@@ -30,12 +37,39 @@ func (s *ServerSpec) Port() int {
 	return s.port
 }
 
+// Retries is how many times to retry a transient failure.
+func (s *ServerSpec) Retries() int {
+	return s.retries
+}
+
+// RetryDelay is how long to wait before the first retry.
+func (s *ServerSpec) RetryDelay() time.Duration {
+	return s.retryDelay
+}
+
+// UrlTemplate returns the template for repo urls, if any.
+func (s *ServerSpec) UrlTemplate() string {
+	return s.urlTemplate
+}
+
+// Hooks are the hooks to run in each of the server's repos.
+func (s *ServerSpec) Hooks() config.Hooks {
+	return s.hooks
+}
+
+// CloneOpts are the options for cloning the server's repos.
+func (s *ServerSpec) CloneOpts() config.CloneOpts {
+	return s.clone
+}
+
 // MakeServerSpec returns a ServerSpec with default values.
 func MakeServerSpec() *ServerSpec {
 	return &ServerSpec{
-		port:    0,
-		scheme:  SchemeSsh,
-		timeout: 4 * time.Minute,
+		port:       0,
+		scheme:     SchemeSsh,
+		timeout:    4 * time.Minute,
+		retries:    0,
+		retryDelay: 2 * time.Second,
 	}
 }
 
@@ -48,6 +82,29 @@ func FromServerOpts(s *config.ServerOpts) (result *ServerSpec, err error) {
 			return nil, fmt.Errorf("bad duration %q in git serverSpec; %w", s.Timeout, err)
 		}
 	}
+	if s.Retries < 0 {
+		return nil, fmt.Errorf("negative retries %d in git serverSpec", s.Retries)
+	}
+	result.retries = s.Retries
+	result.port = s.Port
+	result.hooks = s.Hooks
+	result.clone = s.CloneOpts
+	if s.UrlTemplate != "" {
+		if err = config.CheckUrlTemplate(s.UrlTemplate); err != nil {
+			return nil, err
+		}
+		if s.Port == 0 && strings.Contains(s.UrlTemplate, "{port}") {
+			return nil, fmt.Errorf(
+				"urlTemplate %q uses {port}, but no port is set", s.UrlTemplate)
+		}
+		result.urlTemplate = s.UrlTemplate
+	}
+	if s.RetryDelay != "" {
+		result.retryDelay, err = time.ParseDuration(s.RetryDelay)
+		if err != nil {
+			return nil, fmt.Errorf("bad retryDelay %q in git serverSpec; %w", s.RetryDelay, err)
+		}
+	}
 	if s.Scheme != "" {
 		switch s.Scheme {
 		case SchemeHttps.String():
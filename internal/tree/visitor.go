@@ -6,3 +6,11 @@ type Visitor interface {
 	VisitOrgNode(n *OrgNode)
 	VisitRepoNode(n *RepoNode)
 }
+
+// SkippedRepoVisitor is a Visitor that also visits the repos
+// the config marks skip, e.g. to know that their clones aren't
+// orphans.  Other visitors never see those repos.
+type SkippedRepoVisitor interface {
+	Visitor
+	VisitSkippedRepoNode(n *RepoNode)
+}
@@ -0,0 +1,133 @@
+package visitor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/TwiN/go-color"
+)
+
+// BranchOutcome is the result of rebasing a local
+// branch onto the updated default branch.
+type BranchOutcome int
+
+const (
+	BranchFailed BranchOutcome = iota
+	BranchRebased
+	BranchUpToDate
+	BranchConflictAborted
+)
+
+func (o BranchOutcome) String() string {
+	return []string{
+		color.Red + "error" + color.Reset,
+		color.Blue + "rebased" + color.Reset,
+		color.Green + "up to date" + color.Reset,
+		color.Yellow + "conflict, aborted" + color.Reset,
+	}[o]
+}
+
+// Name is a short, uncolored name for the outcome.
+func (o BranchOutcome) Name() string {
+	return []string{
+		"error",
+		"rebased",
+		"up-to-date",
+		"conflict-aborted",
+	}[o]
+}
+
+// MarshalText supports JSON and YAML output.
+func (o BranchOutcome) MarshalText() ([]byte, error) {
+	return []byte(o.Name()), nil
+}
+
+// BranchReport is the result of rebasing one local branch.
+type BranchReport struct {
+	Name    string        `json:"name" yaml:"name"`
+	Outcome BranchOutcome `json:"outcome" yaml:"outcome"`
+	Error   string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ValidateBranchPatterns returns an error if a pattern is malformed.
+func ValidateBranchPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad branch pattern %q; %w", p, err)
+		}
+	}
+	return nil
+}
+
+// branchesToRebase returns the local branches, other than the
+// default branch, that track the default branch (locally or on
+// a remote), or match one of the patterns.
+func (g *gitRepo) branchesToRebase(def string, patterns []string) ([]string, error) {
+	if err := g.gr.Run(cmdForEachRef,
+		"--format=%(refname:short)%09%(upstream:short)", "refs/heads"); err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{
+		def:                            true,
+		path.Join(remoteOrigin, def):   true,
+		path.Join(remoteUpstream, def): true,
+	}
+	var result []string
+	for _, line := range strings.Split(g.gr.GetOutput(), "\n") {
+		name, upstream, _ := strings.Cut(strings.TrimSpace(line), "\t")
+		if name == "" || name == def {
+			continue
+		}
+		wanted := tracked[upstream]
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				wanted = true
+			}
+		}
+		if wanted {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// rebaseBranches rebases each branch onto the default branch,
+// aborting any rebase that fails, then checks out the head again.
+func (g *gitRepo) rebaseBranches(def string, branches []string, head string) ([]*BranchReport, error) {
+	g.gr.SetPwd(g.n.AbsPath())
+	result := make([]*BranchReport, len(branches))
+	for i, b := range branches {
+		result[i] = g.rebaseBranch(def, b)
+	}
+	if len(branches) > 0 {
+		if err := g.gr.Run(cmdCheckout, head); err != nil {
+			return result, fmt.Errorf("unable to check out %q again; %w", head, err)
+		}
+	}
+	return result, nil
+}
+
+func (g *gitRepo) rebaseBranch(def, b string) *BranchReport {
+	r := &BranchReport{Name: b}
+	if err := g.gr.Run(cmdRevList, "--count", b+".."+def); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if strings.TrimSpace(g.gr.GetOutput()) == "0" {
+		r.Outcome = BranchUpToDate
+		return r
+	}
+	err := g.gr.Run(cmdRebase, def, b)
+	if err == nil {
+		r.Outcome = BranchRebased
+		return r
+	}
+	r.Error = err.Error()
+	// The abort works only if the rebase started, i.e. it conflicted,
+	// rather than failing to start, e.g. due to local changes.
+	if g.gr.Run(cmdRebase, "--abort") == nil {
+		r.Outcome, r.Error = BranchConflictAborted, ""
+	}
+	return r
+}
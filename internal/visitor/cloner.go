@@ -1,11 +1,11 @@
 package visitor
 
 import (
+	"context"
 	"fmt"
 	"strconv"
+	"time"
 
-	"github.com/TwiN/go-color"
-	"github.com/monopole/myrepos/internal/runner"
 	"github.com/monopole/myrepos/internal/tree"
 )
 
@@ -27,99 +27,86 @@ func header(i int, arg string) string {
 	return fmt.Sprintf(fmtHeader, indent(i)+arg)
 }
 
+// Cloner clones the repos that aren't on local storage,
+// and rebases those that are.
 type Cloner struct {
-	lastErr  error
-	fatalErr error
-	gr       *runner.Runner
+	walker
+	opts UpdateOpts
 }
 
-func (v *Cloner) fatal(e error) {
-	v.fatalErr, v.lastErr = e, e
+// NewCloner returns a Cloner that works on at most
+// the given number of repos at once, sending results to out.
+// Canceling the context stops the work.
+func NewCloner(ctx context.Context, jobs int, out Output, opts UpdateOpts) *Cloner {
+	return &Cloner{walker: walker{ctx: ctx, jobs: jobs, out: out}, opts: opts}
 }
 
-func (v *Cloner) Err() error {
-	return v.lastErr
-}
-
-func (v *Cloner) VisitRootNode(n *tree.RootNode) {
-	if v.fatalErr != nil {
-		return
-	}
-	exists, isDir := n.AbsPath().Exists()
-	if !exists {
-		// Make it for instead of complain?
-		// Could trigger a bunch of work if it's just a typo.
-		v.fatal(fmt.Errorf("if you want the root dir %q, make it first", n.AbsPath()))
-		return
-	}
-	if !isDir {
-		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
-		return
-	}
-	fmt.Println(color.InBlackOverGray(header(0, string(n.AbsPath()))))
-}
-
-func (v *Cloner) VisitServerNode(n *tree.ServerNode) {
-	// TODO: Use some simple git command, e.g.
-	//  git remote show origin
-	// to comfirm that the server is reachable.
-	if v.fatalErr != nil {
-		return
-	}
-	if exists, isDir := n.AbsPath().Exists(); exists && !isDir {
-		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
-		return
-	}
-	fmt.Println(color.InBlackOverYellow(header(1, string(n.Domain()))))
+func (v *Cloner) VisitRepoNode(n *tree.RepoNode) {
+	v.addRepo(n, func() func() {
+		r := newRepoReport(n)
+		start := time.Now()
+		update, err := cloneOrRebase(v.ctx, n, r, v.opts)
+		var hookErr error
+		if err == nil {
+			// Hooks failing doesn't undo the git work, so the
+			// outcome stands, and the failure is noted apart.
+			if hookErr = runHooks(v.ctx, n, update); hookErr != nil {
+				r.HookError = hookErr.Error()
+			}
+		} else {
+			if r.Outcome != StashConflict {
+				r.Outcome = Oops
+			}
+			r.Error = err.Error()
+		}
+		r.setDuration(time.Since(start))
+		return func() {
+			// A failed hook still makes the exit status non-zero.
+			if err != nil {
+				v.lastErr = err
+			} else if hookErr != nil {
+				v.lastErr = hookErr
+			}
+			v.out.Repo(r)
+		}
+	})
 }
 
-func (v *Cloner) VisitOrgNode(n *tree.OrgNode) {
-	if v.fatalErr != nil {
-		return
-	}
-	if exists, isDir := n.AbsPath().Exists(); exists && !isDir {
-		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
-		return
+// cloneOrRebase clones or rebases the repo, recording the
+// outcome, the latest commit and the attempts in the report.
+// It returns what it did to the default branch, which, unlike
+// the outcome, ignores any push to a fork's origin.
+func cloneOrRebase(
+	ctx context.Context, n *tree.RepoNode, r *RepoReport, opts UpdateOpts) (Outcome, error) {
+	if exists, _ := n.AbsPath().Exists(); exists {
+		if err := errIfStashConflict(n); err != nil {
+			return Oops, err
+		}
 	}
-	fmt.Println(color.InBlackOverCyan(header(2, string(n.NameDir()))))
-}
-
-func (v *Cloner) VisitRepoNode(n *tree.RepoNode) {
-	if v.fatalErr != nil {
-		return
+	g, err := newGitRepo(ctx, n)
+	if err != nil {
+		return Oops, err
 	}
-	exists, isDir := n.AbsPath().Exists()
-	if exists && !isDir {
-		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
-		return
+	defer func() { r.Attempts = 1 + g.gr.Retries() }()
+	p, err := planFor(g, opts)
+	if err != nil {
+		return Oops, err
 	}
-	var (
-		outcome Outcome
-		err     error
-	)
-	if exists {
-		outcome, err = v.Rebase(n)
-	} else {
-		outcome, err = v.Clone(n)
+	r.DefaultBranch, r.RemoteDefaultBranch = p.branch, p.remoteDefault
+	res, err := g.execute(p)
+	r.Outcome, r.Branches, r.OriginOnly = res.outcome, res.branches, res.originOnly
+	if err != nil && r.Outcome != StashConflict {
+		return res.update, err
 	}
-	if err != nil {
-		v.reportErr(n, err)
-		return
+	// The git work is done, even if restoring stashed changes failed.
+	g.gr.SetPwd(n.AbsPath())
+	var infoErr error
+	// The default branch might not be checked out.
+	if r.LastCommit, infoErr = g.LastLog(p.branch); infoErr == nil {
+		r.Branch, infoErr = currentBranch(g.gr)
 	}
-	var status string
-	status, err = v.LastLog()
-	if err != nil {
-		v.reportErr(n, err)
-		return
+	if err == nil {
+		err = infoErr
 	}
-	v.reportStatus(n, outcome, status)
-}
-
-func (v *Cloner) reportErr(n *tree.RepoNode, err error) {
-	v.lastErr = err
-	v.reportStatus(n, Oops, err.Error())
-}
-
-func (v *Cloner) reportStatus(n *tree.RepoNode, outcome Outcome, status string) {
-	fmt.Printf(fmtReport, n.Name, outcome, status)
+	return res.update, err
 }
@@ -0,0 +1,232 @@
+package visitor_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monopole/myrepos/internal/config"
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/tree"
+	. "github.com/monopole/myrepos/internal/visitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// reports is an Output that keeps the reports.
+type reports []*RepoReport
+
+func (o *reports) Header(int, string) {}
+func (o *reports) Repo(r *RepoReport) { *o = append(*o, r) }
+func (o *reports) Close() error       { return nil }
+
+// git runs git in dir, failing the test if git fails.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// gitSandbox keeps git away from the user's config, and returns
+// a directory holding a "remotes" directory for bare repos, and
+// a "root" directory for clones.
+func gitSandbox(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, v := range []string{"GIT_AUTHOR", "GIT_COMMITTER"} {
+		t.Setenv(v+"_NAME", "tester")
+		t.Setenv(v+"_EMAIL", "tester@example.com")
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "root"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// makeRemote makes a bare repo for the org and repo, holding
+// one commit on main, and returns its path.
+func makeRemote(t *testing.T, dir, org, repo string) string {
+	bare := filepath.Join(dir, "remotes", org, repo+".git")
+	git(t, dir, "init", "--bare", "-b", "main", bare)
+	work := filepath.Join(dir, "work", org, repo)
+	git(t, dir, "clone", bare, work)
+	commit(t, work, "initial")
+	git(t, work, "push", "origin", "HEAD:main")
+	return bare
+}
+
+// commit commits a new file named for the message.
+func commit(t *testing.T, work, msg string) {
+	name := strings.ReplaceAll(msg, " ", "-")
+	if err := os.WriteFile(filepath.Join(work, name), []byte(msg+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, work, "add", name)
+	git(t, work, "commit", "-m", msg)
+}
+
+// sandboxConfig returns a config laying out the given org and
+// repos below the sandbox root, cloned over file:// urls.
+func sandboxConfig(dir string, org config.OrgName, repos ...config.RepoSpec) *config.Config {
+	return &config.Config{
+		Path: file.Path(filepath.Join(dir, "root")),
+		Layout: map[config.ServerDomain]map[config.OrgName][]config.RepoSpec{
+			"github.com": {org: repos},
+		},
+		ServerOpts: map[config.ServerDomain]config.ServerOpts{
+			"github.com": {UrlTemplate: "file://" + filepath.Join(dir, "remotes") + "/{org}/{repo}.git"},
+		},
+	}
+}
+
+// update clones or updates the repos of the config.
+func update(t *testing.T, c *config.Config, opts UpdateOpts) (reports, error) {
+	rn, err := tree.MakeRootNode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out reports
+	v := NewCloner(context.Background(), 1, &out, opts)
+	rn.Accept(v)
+	return out, v.Err()
+}
+
+func TestClonerHookFailureFailsTheWalk(t *testing.T) {
+	dir := gitSandbox(t)
+	makeRemote(t, dir, "monopole", "mdrip")
+	c := sandboxConfig(dir, "monopole", config.RepoSpec{
+		Name:  "mdrip",
+		Hooks: config.Hooks{PostClone: "echo oops; exit 3"},
+	})
+	out, err := update(t, c, UpdateOpts{})
+	assert.Error(t, err)
+	if !assert.Len(t, out, 1) {
+		return
+	}
+	assert.Equal(t, ClonedAt, out[0].Outcome)
+	assert.Empty(t, out[0].Error)
+	assert.Contains(t, out[0].HookError, `postClone hook "echo oops; exit 3" failed`)
+}
+
+// makeFork makes an upstream repo, and a fork of it in origin,
+// and returns working trees of both, for pushing to them.
+func makeFork(t *testing.T, dir, origin, upstream, repo string) (string, string) {
+	makeRemote(t, dir, upstream, repo)
+	bare := filepath.Join(dir, "remotes", origin, repo+".git")
+	git(t, dir, "clone", "--bare", filepath.Join(dir, "remotes", upstream, repo+".git"), bare)
+	work := filepath.Join(dir, "work", origin, repo)
+	git(t, dir, "clone", bare, work)
+	return work, filepath.Join(dir, "work", upstream, repo)
+}
+
+// head returns the commit at the tip of main in the bare repo.
+func head(t *testing.T, dir, org, repo string) string {
+	return git(t, filepath.Join(dir, "remotes", org, repo+".git"), "rev-parse", "main")
+}
+
+func TestClonerPushesLaggingOrigin(t *testing.T) {
+	dir := gitSandbox(t)
+	_, upstream := makeFork(t, dir, "monopole", "kubernetes-sigs", "kustomize")
+	c := sandboxConfig(dir, "k8s|monopole|kubernetes-sigs",
+		config.RepoSpec{Name: "kustomize", NoPush: true})
+	_, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+
+	commit(t, upstream, "new upstream")
+	git(t, upstream, "push", "origin", "HEAD:main")
+	out, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, RebasedTo, out[0].Outcome)
+	assert.NotEqual(t, head(t, dir, "kubernetes-sigs", "kustomize"),
+		head(t, dir, "monopole", "kustomize"))
+
+	// Upstream has nothing new, but origin still lags.
+	c.Layout["github.com"]["k8s|monopole|kubernetes-sigs"][0].NoPush = false
+	out, err = update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, Pushed, out[0].Outcome)
+	assert.Equal(t, head(t, dir, "kubernetes-sigs", "kustomize"),
+		head(t, dir, "monopole", "kustomize"))
+
+	out, err = update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, NoUpdate, out[0].Outcome)
+}
+
+func TestClonerRetriesRefusedPush(t *testing.T) {
+	dir := gitSandbox(t)
+	origin, upstream := makeFork(t, dir, "monopole", "kubernetes-sigs", "kustomize")
+	c := sandboxConfig(dir, "k8s|monopole|kubernetes-sigs", config.RepoSpec{Name: "kustomize"})
+	_, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+
+	commit(t, upstream, "first upstream")
+	git(t, upstream, "push", "origin", "HEAD:main")
+	out, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, RebasedTo, out[0].Outcome)
+	assert.Equal(t, head(t, dir, "kubernetes-sigs", "kustomize"),
+		head(t, dir, "monopole", "kustomize"))
+
+	// Someone pushes to origin from elsewhere, so the lease is stale.
+	git(t, origin, "pull", "--rebase", "origin", "main")
+	commit(t, origin, "from laptop")
+	git(t, origin, "push", "origin", "HEAD:main")
+	commit(t, upstream, "second upstream")
+	git(t, upstream, "push", "origin", "HEAD:main")
+	out, err = update(t, c, UpdateOpts{})
+	assert.Error(t, err)
+	assert.Equal(t, Oops, out[0].Outcome)
+	assert.Contains(t, out[0].Error, "origin changed since it was last seen")
+
+	// Upstream has nothing new, but the push is tried again.
+	out, err = update(t, c, UpdateOpts{})
+	assert.Error(t, err)
+	assert.Contains(t, out[0].Error, "origin changed since it was last seen")
+
+	out, err = update(t, c, UpdateOpts{StrictPush: true})
+	assert.NoError(t, err)
+	assert.Equal(t, PushRefused, out[0].Outcome)
+	if assert.Len(t, out[0].OriginOnly, 1) {
+		assert.Contains(t, out[0].OriginOnly[0], "from laptop")
+	}
+	assert.Equal(t, git(t, origin, "rev-parse", "HEAD"), head(t, dir, "monopole", "kustomize"))
+}
+
+func TestClonerFailedRebaseKeepsHead(t *testing.T) {
+	dir := gitSandbox(t)
+	makeRemote(t, dir, "monopole", "mdrip")
+	c := sandboxConfig(dir, "monopole", config.RepoSpec{Name: "mdrip"})
+	_, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+
+	// Local and upstream commits that conflict, with
+	// some other branch checked out.
+	clone := filepath.Join(dir, "root", "github.com", "monopole", "mdrip")
+	upstream := filepath.Join(dir, "work", "monopole", "mdrip")
+	for work, body := range map[string]string{clone: "mine\n", upstream: "theirs\n"} {
+		if err = os.WriteFile(filepath.Join(work, "f"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git(t, work, "add", "f")
+		git(t, work, "commit", "-m", "f")
+	}
+	git(t, upstream, "push", "origin", "HEAD:main")
+	git(t, clone, "checkout", "-b", "other")
+
+	out, err := update(t, c, UpdateOpts{})
+	assert.Error(t, err)
+	assert.Equal(t, Oops, out[0].Outcome)
+	assert.Equal(t, "other", git(t, clone, "rev-parse", "--abbrev-ref", "HEAD"))
+	_, err = os.Stat(filepath.Join(clone, ".git", "rebase-merge"))
+	assert.True(t, os.IsNotExist(err), "rebase still in progress")
+}
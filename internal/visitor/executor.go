@@ -0,0 +1,98 @@
+package visitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TwiN/go-color"
+	"github.com/monopole/myrepos/internal/runner"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// ExecSummary counts the results of running a command in repos.
+type ExecSummary struct {
+	Passed  int
+	Failed  []string
+	Missing []string
+}
+
+func (s *ExecSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d passed, %d failed, %d not cloned",
+		s.Passed, len(s.Failed), len(s.Missing))
+	for _, f := range s.Failed {
+		b.WriteString("\n  failed: " + f)
+	}
+	return b.String()
+}
+
+// Executor runs a command in each cloned repo's directory.
+type Executor struct {
+	walker
+	program string
+	args    []string
+	summary *ExecSummary
+}
+
+// NewExecutor returns an Executor that runs the given command in
+// at most jobs repos at once, adding its results to the summary.
+func NewExecutor(ctx context.Context, jobs int, summary *ExecSummary, program string, args ...string) *Executor {
+	return &Executor{
+		walker:  newTextWalker(ctx, jobs),
+		program: program,
+		args:    args,
+		summary: summary,
+	}
+}
+
+// Err waits for all repos to finish, then returns the error that
+// stopped the walk, if any.  Failures in repos are in the summary.
+func (v *Executor) Err() error {
+	_ = v.walker.Err()
+	return v.fatalErr
+}
+
+func (v *Executor) VisitRepoNode(n *tree.RepoNode) {
+	v.addRepo(n, func() func() {
+		if exists, _ := n.AbsPath().Exists(); !exists {
+			return func() {
+				v.summary.Missing = append(v.summary.Missing, string(n.AbsPath()))
+				reportStatus(n, color.Yellow+"skipped"+color.Reset, "not cloned")
+			}
+		}
+		r, err := runner.NewRunner(v.ctx, v.program, n.ServerSpec().Timeout(), nil)
+		if err == nil {
+			r.SetPwd(n.AbsPath())
+			err = r.Run(v.args...)
+		}
+		return func() {
+			if err != nil {
+				v.summary.Failed = append(v.summary.Failed, string(n.AbsPath()))
+				// The command and dir are known, so skip the
+				// runner's elaboration of the error.
+				if e := errors.Unwrap(err); e != nil {
+					err = e
+				}
+				reportStatus(n, Oops.String(), err.Error())
+			} else {
+				v.summary.Passed++
+				reportStatus(n, color.Green+"ok"+color.Reset, "")
+			}
+			if r != nil {
+				printIndented(r.GetOutput())
+			}
+		}
+	})
+}
+
+func printIndented(output string) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fmt.Println(indent(4) + line)
+	}
+}
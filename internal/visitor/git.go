@@ -1,16 +1,27 @@
 package visitor
 
 import (
+	"context"
 	"fmt"
 	"github.com/monopole/myrepos/internal/runner"
 	"github.com/monopole/myrepos/internal/ssh"
 	"github.com/monopole/myrepos/internal/tree"
-	"path"
+	"strconv"
 	"strings"
 )
 
 const gitProgram = "git"
 
+// transientErrs are substrings of errors that might not
+// happen if the command is retried.
+var transientErrs = []string{
+	"Could not resolve hostname",
+	"Connection refused",
+	"Connection reset by peer",
+	"Connection timed out",
+	"Operation timed out",
+}
+
 // commonErrs maps common error substrings to one line summaries.
 var commonErrs = map[string]string{
 	"Could not resolve hostname":    "cannot reach host",
@@ -18,22 +29,56 @@ var commonErrs = map[string]string{
 	"You have unstaged changes":     "unstaged changes - commit or stash first",
 	"Operation timed out":           "timed out - is repo accessible?",
 	"Permission denied (publickey)": ssh.NoSshKeysErr,
+	"(stale info)":                  "origin changed since it was last seen - not pushing",
 }
 
 const (
-	remoteUpstream = "upstream"
-	remoteOrigin   = "origin"
-	cmdDiff        = "diff"
-	cmdFetch       = "fetch"
-	cmdRebase      = "rebase"
-	cmdClone       = "clone"
-	cmdRemote      = "remote"
-	cmdLog         = "log"
-	cmdBranch      = "branch"
-	cmdPush        = "push"
-	cmdCheckout    = "checkout"
+	remoteUpstream    = "upstream"
+	remoteOrigin      = "origin"
+	cmdDiff           = "diff"
+	cmdFetch          = "fetch"
+	cmdRebase         = "rebase"
+	cmdClone          = "clone"
+	cmdRemote         = "remote"
+	cmdLog            = "log"
+	cmdBranch         = "branch"
+	cmdPush           = "push"
+	cmdCheckout       = "checkout"
+	cmdStatus         = "status"
+	cmdRevParse       = "rev-parse"
+	cmdRevList        = "rev-list"
+	cmdMergeBase      = "merge-base"
+	cmdSparseCheckout = "sparse-checkout"
+	cmdForEachRef     = "for-each-ref"
+	cmdLsRemote       = "ls-remote"
+	optNoLocks        = "--no-optional-locks"
 )
 
+// isRetryable returns true if the git arguments talk to a remote,
+// and only read from it, updating at most remote-tracking refs,
+// so that running them again after a failure or timeout is safe.
+// A clone isn't, since a killed clone leaves a partial directory
+// that the next try refuses to clone into, and neither is a push,
+// since a push that timed out might have landed, staling its lease.
+func isRetryable(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case cmdLsRemote:
+		return true
+	case cmdFetch:
+		// Fetching from "." updates local branches.
+		for _, a := range args[1:] {
+			if a == "." {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func deQuote(arg string) string {
 	return strings.Trim(arg, `'"`)
 }
@@ -46,94 +91,245 @@ func firstLine(arg string) string {
 	return deQuote(arg)
 }
 
-// Clone attempts to clone the repo.
-func (v *Cloner) Clone(n *tree.RepoNode) (Outcome, error) {
-	var err error
-	if err = n.AbsPath().MkDir(); err != nil {
-		return Oops, fmt.Errorf("unable to make cloning location %w", err)
-	}
-	v.gr, err = runner.NewRunner(gitProgram, n.ServerSpec().Timeout(), commonErrs)
+// gitRepo runs git commands on behalf of one repo node.
+// Each repo gets its own, so that repos can be processed
+// concurrently.
+type gitRepo struct {
+	n  *tree.RepoNode
+	gr *runner.Runner
+}
+
+func newGitRepo(ctx context.Context, n *tree.RepoNode) (*gitRepo, error) {
+	gr, err := runner.NewRunner(ctx, gitProgram, n.ServerSpec().Timeout(), commonErrs)
 	if err != nil {
-		return Oops, err
+		return nil, err
 	}
-	v.gr.SetPwd(n.AbsParent())
-	if err = v.gr.Run(cmdClone, n.UrlOrigin()); err != nil {
-		return Oops, err
+	gr.SetRetry(runner.Retry{
+		Count:     n.ServerSpec().Retries(),
+		Delay:     n.ServerSpec().RetryDelay(),
+		Transient: transientErrs,
+		Retryable: isRetryable,
+	})
+	return &gitRepo{n: n, gr: gr}, nil
+}
+
+// execute runs the plan's steps, stopping at the first error,
+// then rebases the branches the plan names, if any, onto the
+// updated default branch.  If the plan says to, local changes
+// are stashed first, and restored after.
+func (g *gitRepo) execute(p *plan) (*result, error) {
+	r := &result{outcome: Oops}
+	if p.mkDir != "" {
+		if err := p.mkDir.MkDir(); err != nil {
+			return r, fmt.Errorf("unable to make cloning location %w", err)
+		}
 	}
-	// Dive in and check it.
-	v.gr.SetPwd(n.AbsPath())
-	if n.IsAFork() {
-		fullSpec := n.UrlUpstream()
-		if err = v.gr.Run(cmdRemote, "add", remoteUpstream, fullSpec); err != nil {
-			return Oops, err
+	stashed := false
+	if p.stash != StashNone {
+		var err error
+		g.gr.SetPwd(g.n.AbsPath())
+		if stashed, err = g.stash(p.stash); err != nil {
+			return r, err
 		}
-		if err = v.gr.Run(
-			cmdRemote, "set-url", "--push", remoteUpstream,
-			"disableFootGun_"+fullSpec); err != nil {
-			return Oops, err
+	}
+	err := g.runSteps(p, r)
+	if err == nil {
+		r.branches, err = g.rebaseBranches(p.branch, p.branches, p.head)
+	}
+	if err != nil {
+		r.outcome = Oops
+		if stashed {
+			err = fmt.Errorf("%w; local changes are in 'git stash list'", err)
+		}
+		return r, err
+	}
+	if stashed {
+		g.gr.SetPwd(g.n.AbsPath())
+		if err = g.unstash(); err != nil {
+			r.outcome = StashConflict
+			return r, err
 		}
 	}
-	return ClonedAt, v.gr.Run(cmdRemote, "-v")
+	return r, nil
 }
 
-// Rebase switches to the default branch and rebases.
-func (v *Cloner) Rebase(n *tree.RepoNode) (Outcome, error) {
-	var err error
-	v.gr, err = runner.NewRunner(gitProgram, n.ServerSpec().Timeout(), commonErrs)
+// runSteps runs the plan's steps, then, if they worked, its
+// push steps, and records the outcome in the result.
+func (g *gitRepo) runSteps(p *plan, r *result) error {
+	done, err := g.runStepList(p.steps, r)
 	if err != nil {
-		return Oops, err
+		r.outcome = Oops
+		return err
+	}
+	r.outcome = p.outcome
+	if !done {
+		r.outcome = NoUpdate
+	}
+	r.update = r.outcome
+	if len(p.push) == 0 {
+		return nil
+	}
+	if done, err = g.runStepList(p.push, r); err != nil {
+		r.outcome = Oops
+		return err
 	}
-	v.gr.SetPwd(n.AbsPath())
-	if err = v.gr.Run(cmdCheckout, n.DefaultBranch); err != nil {
-		return Oops, fmt.Errorf(
-			"unable to checkout default branch %q; %w", n.DefaultBranch, err)
+	switch {
+	case r.originOnly != nil:
+		r.outcome = PushRefused
+	case done && r.outcome == NoUpdate:
+		r.outcome = Pushed
 	}
-	if n.IsAFork() {
-		if err = v.gr.Run(cmdFetch, remoteUpstream); err != nil {
-			return Oops, err
+	return nil
+}
+
+// runStepList runs the steps, stopping at the first error.  It
+// returns false if a step ended the list early, i.e. a step with
+// stopIfSilent had no output, or a step with refuseIfOutput had
+// some, which the result records.
+func (g *gitRepo) runStepList(steps []*step, r *result) (bool, error) {
+	for _, s := range steps {
+		g.gr.SetPwd(s.dir)
+		err := g.gr.Run(s.args...)
+		if err != nil && s.deepenFrom != "" {
+			err = g.deepen(s, err)
 		}
-		if err = v.gr.Run(cmdDiff, path.Join(remoteUpstream, n.DefaultBranch)); err != nil {
-			return Oops, err
+		if err != nil && len(s.fallback) > 0 {
+			_, err = g.runStepList(s.fallback, r)
 		}
-		if len(v.gr.GetOutput()) == 0 {
-			return NoUpdate, nil
+		if err != nil {
+			if s.errMsg != "" {
+				err = fmt.Errorf("%s; %w", s.errMsg, err)
+			}
+			return false, g.undo(s, err)
 		}
-		if err = v.gr.Run(cmdRebase, path.Join(remoteUpstream, n.DefaultBranch)); err != nil {
-			return Oops, err
+		output := strings.TrimSpace(g.gr.GetOutput())
+		if s.stopIfSilent && len(output) == 0 {
+			return false, nil
 		}
-		if err = v.gr.Run(cmdPush, "-f", remoteOrigin, n.DefaultBranch); err != nil {
-			return Oops, err
+		if s.refuseIfOutput && len(output) > 0 {
+			r.originOnly = strings.Split(output, "\n")
+			return false, nil
 		}
-		return RebasedTo, nil
 	}
-	if err = v.gr.Run(cmdFetch, remoteOrigin); err != nil {
-		return Oops, err
+	return true, nil
+}
+
+// undo runs the step's undo steps after the step failed with
+// the given error, and returns that error, noting any undo steps
+// that failed unexpectedly too.
+func (g *gitRepo) undo(s *step, stepErr error) error {
+	for _, u := range s.undo {
+		g.gr.SetPwd(u.dir)
+		if err := g.gr.Run(u.args...); err != nil && !u.mayFail {
+			stepErr = fmt.Errorf("%w; then, %v", stepErr, err)
+		}
 	}
-	if err = v.gr.Run(cmdDiff, path.Join(remoteOrigin, n.DefaultBranch)); err != nil {
-		return Oops, err
+	return stepErr
+}
+
+const (
+	// deepenBy is how many commits the first deepening fetches;
+	// each later one fetches twice as many.
+	deepenBy = 100
+	// deepenTries is how many times to deepen, the last time
+	// fetching all the history.
+	deepenTries = 3
+)
+
+// deepen fetches more history from the step's remote until the
+// step works, if the repo is shallow.  If it isn't, or the step
+// never works, deepen returns the step's error.
+func (g *gitRepo) deepen(s *step, stepErr error) error {
+	if err := g.gr.Run(cmdRevParse, "--is-shallow-repository"); err != nil {
+		return err
+	}
+	if strings.TrimSpace(g.gr.GetOutput()) != "true" {
+		return stepErr
+	}
+	for i := 0; i < deepenTries; i++ {
+		how := "--deepen=" + strconv.Itoa(deepenBy<<i)
+		if i == deepenTries-1 {
+			how = "--unshallow"
+		}
+		if err := g.gr.Run(cmdFetch, how, s.deepenFrom); err != nil {
+			return err
+		}
+		if stepErr = g.gr.Run(s.args...); stepErr == nil {
+			return nil
+		}
+	}
+	return stepErr
+}
+
+// defaultBranch returns the repo's default branch, and the
+// branch named by the remote's HEAD, where the remote is upstream
+// for a fork.  The default branch is the pinned one, if any, else
+// the remote's.  If the default branch is pinned, not finding the
+// remote's isn't an error, and it's returned as "".
+func (g *gitRepo) defaultBranch() (string, string, error) {
+	url := g.n.UrlOrigin()
+	if g.n.IsAFork() {
+		url = g.n.UrlUpstream()
+	}
+	remote, err := g.remoteHead(url)
+	if g.n.BranchPinned() {
+		return g.n.DefaultBranch, remote, nil
 	}
-	if len(v.gr.GetOutput()) == 0 {
-		return NoUpdate, nil
+	if err != nil {
+		return "", "", fmt.Errorf("unable to find the default branch; %w", err)
 	}
-	if err = v.gr.Run(cmdRebase, path.Join(remoteOrigin, n.DefaultBranch)); err != nil {
-		return Oops, err
+	return remote, remote, nil
+}
+
+// remoteHead returns the branch named by the HEAD
+// of the repo at the url, or "" if there's an error.
+func (g *gitRepo) remoteHead(url string) (string, error) {
+	// The repo might not be cloned yet.
+	g.gr.SetPwd("")
+	if err := g.gr.Run(cmdLsRemote, "--symref", url, "HEAD"); err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(g.gr.GetOutput(), "\n") {
+		if !strings.HasPrefix(line, "ref: refs/heads/") {
+			continue
+		}
+		if b, _, ok := strings.Cut(strings.TrimPrefix(line, "ref: refs/heads/"), "\t"); ok {
+			return b, nil
+		}
 	}
-	return RebasedTo, nil
+	return "", fmt.Errorf("no HEAD branch at %s", url)
 }
 
-func (v *Cloner) LastLog() (string, error) {
-	if v.gr == nil {
-		return "", fmt.Errorf("no git process")
+// head returns the checked out branch,
+// or the commit if HEAD is detached.
+func (g *gitRepo) head() (string, error) {
+	g.gr.SetPwd(g.n.AbsPath())
+	if err := g.gr.Run(cmdRevParse, "--abbrev-ref", "HEAD"); err != nil {
+		return "", err
+	}
+	if b := strings.TrimSpace(g.gr.GetOutput()); b != "HEAD" {
+		return b, nil
+	}
+	if err := g.gr.Run(cmdRevParse, "HEAD"); err != nil {
+		return "", err
 	}
-	if err := v.gr.Run(
+	return strings.TrimSpace(g.gr.GetOutput()), nil
+}
+
+// LastLog returns a one line summary of the most recent
+// commit on the given ref, e.g. HEAD.
+func (g *gitRepo) LastLog(ref string) (string, error) {
+	if err := g.gr.Run(
 		cmdLog,
 		`--pretty=format:"%<(26)%ad%>(30)%an : %s"`,
 		`--date=human`,
 		`--abbrev=8`,
 		`--max-count=1`,
+		ref,
+		"--",
 	); err != nil {
 
 		return "", err
 	}
-	return firstLine(v.gr.GetOutput()), nil
+	return firstLine(g.gr.GetOutput()), nil
 }
@@ -0,0 +1,57 @@
+package visitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/monopole/myrepos/internal/runner"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+const shellProgram = "sh"
+
+// hooksFor returns the name and commands of the hooks
+// to run after git work with the given outcome.
+func hooksFor(n *tree.RepoNode, o Outcome) (string, []string) {
+	switch o {
+	case ClonedAt:
+		return "postClone", n.PostClone()
+	case RebasedTo:
+		return "postUpdate", n.PostUpdate()
+	default:
+		return "", nil
+	}
+}
+
+// runHooks runs the hooks for the outcome in the repo's
+// directory, stopping at the first failure.
+func runHooks(ctx context.Context, n *tree.RepoNode, o Outcome) error {
+	name, hooks := hooksFor(n, o)
+	if len(hooks) == 0 {
+		return nil
+	}
+	r, err := runner.NewRunner(ctx, shellProgram, n.ServerSpec().Timeout(), nil)
+	if err != nil {
+		return err
+	}
+	r.SetPwd(n.AbsPath())
+	for _, h := range hooks {
+		if err = r.Run("-c", h); err == nil {
+			continue
+		}
+		// The command and dir are known, so skip the
+		// runner's elaboration of the error.
+		if e := errors.Unwrap(err); e != nil {
+			err = e
+		}
+		msg := fmt.Sprintf("%s hook %q failed: %v", name, firstLine(h), err)
+		if out := strings.TrimSpace(r.GetOutput()); out != "" {
+			lines := strings.Split(out, "\n")
+			msg += "; " + lines[len(lines)-1]
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
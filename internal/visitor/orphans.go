@@ -0,0 +1,187 @@
+package visitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// Orphan is a directory below a root that no node accounts for.
+type Orphan struct {
+	// Root is the root directory the orphan was found under.
+	Root file.Path
+	// Path is the absolute path to the orphan.
+	Path file.Path
+	// IsRepo is true if the orphan is a git repository.
+	IsRepo bool
+}
+
+func (o *Orphan) String() string {
+	if o.IsRepo {
+		return "repo " + string(o.Path)
+	}
+	return "dir  " + string(o.Path)
+}
+
+// MoveTo moves the orphan into the given directory, keeping
+// its path relative to its root, and returns its new path.
+func (o *Orphan) MoveTo(dir file.Path) (file.Path, error) {
+	rel, err := filepath.Rel(string(o.Root), string(o.Path))
+	if err != nil {
+		return "", err
+	}
+	target := dir.Append(file.Path(rel))
+	if exists, _ := target.Exists(); exists {
+		return "", fmt.Errorf("cannot move %q; %q already exists", o.Path, target)
+	}
+	if err = file.Path(filepath.Dir(string(target))).MkDir(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(string(o.Path), string(target)); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// OrphanFinder remembers the directories of the nodes it visits,
+// so that it can find directories on disk that aren't in any tree.
+// One finder can visit many trees, e.g. trees from different
+// config files that share a root.
+type OrphanFinder struct {
+	// roots holds the root directories.
+	roots map[file.Path]bool
+	// repos holds the repository directories.
+	repos map[file.Path]bool
+	// inner holds the directories between the roots and the repos.
+	inner map[file.Path]bool
+}
+
+// NewOrphanFinder returns an OrphanFinder.
+func NewOrphanFinder() *OrphanFinder {
+	return &OrphanFinder{
+		roots: make(map[file.Path]bool),
+		repos: make(map[file.Path]bool),
+		inner: make(map[file.Path]bool),
+	}
+}
+
+func (v *OrphanFinder) VisitRootNode(n *tree.RootNode) {
+	v.roots[n.AbsPath()] = true
+}
+
+func (v *OrphanFinder) VisitServerNode(n *tree.ServerNode) {
+	v.inner[n.AbsPath()] = true
+}
+
+func (v *OrphanFinder) VisitOrgNode(n *tree.OrgNode) {
+	// With nested groups, there are directories between the
+	// server and the org.
+	server := n.Server().AbsPath()
+	for p := n.AbsPath(); len(p) > len(server); p = file.Path(filepath.Dir(string(p))) {
+		v.inner[p] = true
+	}
+}
+
+func (v *OrphanFinder) VisitRepoNode(n *tree.RepoNode) {
+	v.repos[n.AbsPath()] = true
+}
+
+// VisitSkippedRepoNode records a skipped repo, since its
+// clone, if any, is still wanted.
+func (v *OrphanFinder) VisitSkippedRepoNode(n *tree.RepoNode) {
+	v.repos[n.AbsPath()] = true
+}
+
+// Orphans walks the directories below each root, and returns
+// those not accounted for by a visited node.  Directories at the
+// server depth that don't belong to a server are only reported
+// if they hold git repos at the org/repo depth, since the root
+// might be shared with unrelated directories, e.g. if it's $HOME.
+// Directories named in skip aren't reported or searched.
+func (v *OrphanFinder) Orphans(skip ...file.Path) ([]*Orphan, error) {
+	skipped := make(map[file.Path]bool)
+	for _, p := range skip {
+		skipped[p] = true
+	}
+	var result []*Orphan
+	for _, root := range sortedPaths(v.roots) {
+		if err := v.findBelow(root, root, skipped, &result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (v *OrphanFinder) findBelow(
+	root, dir file.Path, skipped map[file.Path]bool, result *[]*Orphan) error {
+	dirs, err := subDirs(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		switch {
+		case skipped[d] || v.repos[d]:
+		case v.inner[d]:
+			if err = v.findBelow(root, d, skipped, result); err != nil {
+				return err
+			}
+		case dir == root && !holdsRepos(d, 2):
+		default:
+			*result = append(*result, &Orphan{Root: root, Path: d, IsRepo: isGitRepo(d)})
+		}
+	}
+	return nil
+}
+
+// holdsRepos is true if there's a git repo exactly depth levels below dir.
+func holdsRepos(dir file.Path, depth int) bool {
+	if depth == 0 {
+		return isGitRepo(dir)
+	}
+	dirs, err := subDirs(dir)
+	if err != nil {
+		return false
+	}
+	for _, d := range dirs {
+		if holdsRepos(d, depth-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGitRepo(dir file.Path) bool {
+	exists, _ := dir.Append(".git").Exists()
+	return exists
+}
+
+// subDirs returns the sorted subdirectories of dir, skipping hidden ones.
+func subDirs(dir file.Path) ([]file.Path, error) {
+	entries, err := os.ReadDir(string(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result []file.Path
+	for _, e := range entries {
+		if e.IsDir() && e.Name()[0] != '.' {
+			result = append(result, dir.Append(file.Path(e.Name())))
+		}
+	}
+	return result, nil
+}
+
+func sortedPaths(m map[file.Path]bool) []file.Path {
+	result := make([]file.Path, 0, len(m))
+	for p := range m {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,38 @@
+package visitor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monopole/myrepos/internal/config"
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/tree"
+	. "github.com/monopole/myrepos/internal/visitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphansKeepsSkippedRepos(t *testing.T) {
+	root := t.TempDir()
+	for _, repo := range []string{"mdrip", "kept", "gone"} {
+		assert.NoError(t, os.MkdirAll(
+			filepath.Join(root, "github.com", "monopole", repo, ".git"), 0o755))
+	}
+	rn, err := tree.MakeRootNode(&config.Config{
+		Path: file.Path(root),
+		Layout: map[config.ServerDomain]map[config.OrgName][]config.RepoSpec{
+			"github.com": {"monopole": {
+				{Name: "mdrip"},
+				{Name: "kept", Skip: true},
+			}},
+		},
+	})
+	assert.NoError(t, err)
+	v := NewOrphanFinder()
+	rn.Accept(v)
+	orphans, err := v.Orphans()
+	assert.NoError(t, err)
+	assert.Len(t, orphans, 1)
+	assert.Equal(t,
+		file.Path(filepath.Join(root, "github.com", "monopole", "gone")), orphans[0].Path)
+}
@@ -9,6 +9,15 @@ const (
 	RebasedTo
 	ClonedAt
 	NoUpdate
+	// StashConflict means the update worked, but restoring
+	// the stashed local changes afterwards conflicted.
+	StashConflict
+	// PushRefused means the update worked, but the fork's
+	// origin had commits not in upstream, so wasn't pushed to.
+	PushRefused
+	// Pushed means the fork's default branch was up to date,
+	// but its origin wasn't, so was pushed to.
+	Pushed
 )
 
 func (o Outcome) String() string {
@@ -17,5 +26,26 @@ func (o Outcome) String() string {
 		color.Blue + "rebased to" + color.Reset,
 		color.Green + "cloned to latest at" + color.Reset,
 		color.Green + "no change since" + color.Reset,
+		color.Red + "stash conflict" + color.Reset,
+		color.Yellow + "push refused" + color.Reset,
+		color.Blue + "pushed origin to" + color.Reset,
 	}[o]
 }
+
+// Name is a short, uncolored name for the outcome.
+func (o Outcome) Name() string {
+	return []string{
+		"error",
+		"rebased",
+		"cloned",
+		"unchanged",
+		"stash-conflict",
+		"push-refused",
+		"pushed",
+	}[o]
+}
+
+// MarshalText supports JSON and YAML output.
+func (o Outcome) MarshalText() ([]byte, error) {
+	return []byte(o.Name()), nil
+}
@@ -0,0 +1,176 @@
+package visitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/TwiN/go-color"
+	"github.com/monopole/myrepos/internal/tree"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+	FormatYaml = "yaml"
+)
+
+// RepoReport is the result of working on one repo.
+type RepoReport struct {
+	Server   string   `json:"server" yaml:"server"`
+	OrgDir   string   `json:"orgDir" yaml:"orgDir"`
+	Origin   string   `json:"origin" yaml:"origin"`
+	Upstream string   `json:"upstream" yaml:"upstream"`
+	Name     string   `json:"name" yaml:"name"`
+	AbsPath  string   `json:"absPath" yaml:"absPath"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// DefaultBranch is the branch that's updated.
+	DefaultBranch string `json:"defaultBranch" yaml:"defaultBranch"`
+	// RemoteDefaultBranch is set if DefaultBranch is pinned,
+	// but the remote's HEAD names this other branch.
+	RemoteDefaultBranch string `json:"remoteDefaultBranch,omitempty" yaml:"remoteDefaultBranch,omitempty"`
+	// Branch is the checked out branch after the work, or a
+	// short commit hash in parentheses if HEAD is detached.
+	Branch     string  `json:"branch,omitempty" yaml:"branch,omitempty"`
+	Outcome    Outcome `json:"outcome" yaml:"outcome"`
+	LastCommit string  `json:"lastCommit,omitempty" yaml:"lastCommit,omitempty"`
+	Error      string  `json:"error,omitempty" yaml:"error,omitempty"`
+	// Branches are the local branches rebased onto the
+	// default branch, if that was asked for.
+	Branches []*BranchReport `json:"branches,omitempty" yaml:"branches,omitempty"`
+	// OriginOnly lists the commits in a fork's origin that
+	// aren't in upstream, if they kept origin from being pushed to.
+	OriginOnly []string `json:"originOnly,omitempty" yaml:"originOnly,omitempty"`
+	// HookError is set if git succeeded, but a hook failed.
+	HookError string `json:"hookError,omitempty" yaml:"hookError,omitempty"`
+	// Attempts is one more than the number of retries
+	// due to transient failures.
+	Attempts int `json:"attempts" yaml:"attempts"`
+	// Duration is how long the work took, e.g. "2.5s".
+	Duration string `json:"duration" yaml:"duration"`
+}
+
+func newRepoReport(n *tree.RepoNode) *RepoReport {
+	return &RepoReport{
+		Server:        string(n.Org().Server().Domain()),
+		OrgDir:        string(n.Org().NameDir()),
+		Origin:        n.NameOrigin(),
+		Upstream:      n.NameUpstream(),
+		Name:          n.Name,
+		AbsPath:       string(n.AbsPath()),
+		Tags:          n.Tags(),
+		DefaultBranch: n.DefaultBranch,
+	}
+}
+
+func (r *RepoReport) setDuration(d time.Duration) {
+	r.Duration = d.Round(time.Millisecond).String()
+}
+
+// Output receives the results of a walk over a tree.
+type Output interface {
+	// Header announces a node above the repo level, at
+	// the given depth below the root.
+	Header(depth int, name string)
+	// Repo delivers a repo's report.
+	Repo(r *RepoReport)
+	// Close writes anything held back.
+	Close() error
+}
+
+// NewOutput returns an Output writing the given format to w.
+func NewOutput(format string, w io.Writer) (Output, error) {
+	switch format {
+	case "", FormatText:
+		return &textOutput{w: w}, nil
+	case FormatJson, FormatYaml:
+		return &structuredOutput{w: w, format: format}, nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown output format %q; use %s, %s or %s",
+			format, FormatText, FormatJson, FormatYaml)
+	}
+}
+
+// textOutput writes colored, fixed width lines for a terminal.
+type textOutput struct {
+	w io.Writer
+}
+
+var headerColors = []func(interface{}) string{
+	color.InBlackOverGray,
+	color.InBlackOverYellow,
+	color.InBlackOverCyan,
+}
+
+func (o *textOutput) Header(depth int, name string) {
+	fmt.Fprintln(o.w, headerColors[depth%len(headerColors)](header(depth, name)))
+}
+
+func (o *textOutput) Repo(r *RepoReport) {
+	status := r.LastCommit
+	if r.Error != "" {
+		status = r.Error
+	}
+	if r.Branch != "" && r.Branch != r.DefaultBranch {
+		status = fmt.Sprintf("[on %s] %s", r.Branch, status)
+	}
+	if r.Attempts > 1 {
+		status = fmt.Sprintf("(%d attempts) %s", r.Attempts, status)
+	}
+	fmt.Fprintf(o.w, fmtReport, r.Name, r.Outcome, status)
+	if r.RemoteDefaultBranch != "" {
+		fmt.Fprintln(o.w, indent(4)+color.Yellow+
+			pinnedMismatch(r.DefaultBranch, r.RemoteDefaultBranch)+color.Reset)
+	}
+	for _, c := range r.OriginOnly {
+		fmt.Fprintln(o.w, indent(4)+color.Yellow+"only in origin: "+c+color.Reset)
+	}
+	for _, b := range r.Branches {
+		fmt.Fprintf(o.w, fmtReport, "branch "+b.Name, b.Outcome, b.Error)
+	}
+	if r.HookError != "" {
+		fmt.Fprintln(o.w, indent(4)+color.Red+r.HookError+color.Reset)
+	}
+}
+
+func (o *textOutput) Close() error {
+	return nil
+}
+
+// structuredOutput holds reports, and writes them as a
+// single JSON or YAML list on Close.  Headers are dropped,
+// since each report names its server and org.
+type structuredOutput struct {
+	w       io.Writer
+	format  string
+	reports []*RepoReport
+}
+
+func (o *structuredOutput) Header(int, string) {}
+
+func (o *structuredOutput) Repo(r *RepoReport) {
+	// Column padding helps a terminal, not a parser.
+	r.LastCommit = strings.Join(strings.Fields(r.LastCommit), " ")
+	o.reports = append(o.reports, r)
+}
+
+func (o *structuredOutput) Close() error {
+	if o.reports == nil {
+		o.reports = []*RepoReport{}
+	}
+	if o.format == FormatJson {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o.reports)
+	}
+	enc := yaml.NewEncoder(o.w)
+	enc.SetIndent(2)
+	if err := enc.Encode(o.reports); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,432 @@
+package visitor
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/TwiN/go-color"
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// step is one git command in a plan.
+type step struct {
+	// dir is the working directory of the command.
+	dir file.Path
+	// args are the arguments to git.
+	args []string
+	// errMsg, if not empty, is prepended to any error.
+	errMsg string
+	// stopIfSilent ends the plan with NoUpdate if the
+	// command produces no output; in the push steps, it
+	// ends them without pushing.
+	stopIfSilent bool
+	// deepenFrom, if not empty, names a remote to fetch more
+	// history from if the command fails in a shallow clone,
+	// before trying the command again.
+	deepenFrom string
+	// fallback, if not empty, are steps to run instead
+	// if the command fails.
+	fallback []*step
+	// undo, if not empty, are steps to run if the command
+	// fails, to put the repo back as it was before the error
+	// is returned.
+	undo []*step
+	// mayFail, for an undo step, says it's expected to fail
+	// sometimes, e.g. aborting a rebase that never started,
+	// so its failure isn't noted.
+	mayFail bool
+	// refuseIfOutput ends the push steps with PushRefused if
+	// the command produces output, which lists the commits in
+	// origin that aren't in upstream.
+	refuseIfOutput bool
+}
+
+func (s *step) String() string {
+	var b strings.Builder
+	b.WriteString(gitProgram + " -C " + quoteIfNeeded(string(s.dir)))
+	for _, a := range s.args {
+		b.WriteString(" " + quoteIfNeeded(a))
+	}
+	if s.stopIfSilent {
+		b.WriteString("   # stop here if no output")
+	}
+	if s.refuseIfOutput {
+		b.WriteString("   # don't push if any output")
+	}
+	if s.deepenFrom != "" {
+		b.WriteString("   # if this fails, deepen from " + s.deepenFrom)
+	}
+	return b.String()
+}
+
+func quoteIfNeeded(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]#~%") {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
+
+// plan is what must be done to bring one repo up to date.
+type plan struct {
+	// mkDir, if not empty, is a directory to make before running steps.
+	mkDir file.Path
+	// steps are the git commands to run in order.
+	steps []*step
+	// push are the steps that push a fork's default branch to
+	// origin.  They run after steps, even if those found that
+	// the default branch was up to date, since origin might not be.
+	push []*step
+	// outcome is the result of running all the steps.
+	outcome Outcome
+	// stash says which local changes to stash before
+	// the steps, and restore after.
+	stash Stash
+	// branches are local branches to rebase onto the
+	// default branch after the steps.
+	branches []string
+	// head is the branch, or commit, checked out before
+	// the plan runs.
+	head string
+	// branch is the default branch that the plan updates.
+	branch string
+	// remoteDefault, if not empty, is the default branch named
+	// by the remote's HEAD, which isn't the pinned branch.
+	remoteDefault string
+}
+
+// result is what running a plan did.
+type result struct {
+	outcome Outcome
+	// update is what the steps did to the default branch,
+	// whatever the push steps did after.
+	update Outcome
+	// branches are the results of rebasing local branches.
+	branches []*BranchReport
+	// originOnly lists the commits in origin that aren't in
+	// upstream, if they kept a fork from being pushed.
+	originOnly []string
+}
+
+// UpdateOpts are choices about how to update existing clones.
+type UpdateOpts struct {
+	// Stash says which local changes to stash while updating.
+	Stash Stash
+	// RebaseBranches says to rebase local branches that track
+	// the default branch onto it, after updating it.
+	RebaseBranches bool
+	// BranchPatterns are glob patterns naming more local
+	// branches to rebase, if RebaseBranches is set.
+	BranchPatterns []string
+	// StrictPush keeps a fork's default branch from being
+	// pushed to origin if origin has commits not in upstream.
+	StrictPush bool
+}
+
+// planFor returns a plan to clone the repo if it's not
+// on local storage, or to rebase it if it is.
+func planFor(g *gitRepo, opts UpdateOpts) (*plan, error) {
+	n := g.n
+	branch, remoteBranch, err := g.defaultBranch()
+	if err != nil {
+		return nil, err
+	}
+	if remoteBranch == branch {
+		remoteBranch = ""
+	}
+	if exists, _ := n.AbsPath().Exists(); !exists {
+		p := planClone(n, branch)
+		p.branch, p.remoteDefault = branch, remoteBranch
+		return p, nil
+	}
+	head, err := g.head()
+	if err != nil {
+		return nil, err
+	}
+	p := planRebase(n, branch, head, opts.StrictPush)
+	p.branch, p.remoteDefault = branch, remoteBranch
+	p.stash, p.head = opts.Stash, head
+	if opts.RebaseBranches {
+		if p.branches, err = g.branchesToRebase(branch, opts.BranchPatterns); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// planClone returns a plan to clone the repo.
+func planClone(n *tree.RepoNode, branch string) *plan {
+	args := []string{cmdClone}
+	co := n.Clone
+	if co.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(co.Depth))
+	}
+	if co.Filter != "" {
+		args = append(args, "--filter="+co.Filter)
+	}
+	args = append(args, "--branch", branch)
+	if co.SingleBranch {
+		args = append(args, "--single-branch")
+	}
+	if len(co.Sparse) > 0 {
+		args = append(args, "--sparse")
+	}
+	args = append(args, n.UrlOrigin())
+	if string(n.NameDir()) != n.Name {
+		args = append(args, string(n.NameDir()))
+	}
+	p := &plan{
+		mkDir:   n.AbsPath(),
+		steps:   []*step{{dir: n.AbsParent(), args: args}},
+		outcome: ClonedAt,
+	}
+	if len(co.Sparse) > 0 {
+		p.steps = append(p.steps,
+			&step{args: append([]string{cmdSparseCheckout, "set"}, co.Sparse...)})
+	}
+	if n.IsAFork() {
+		fullSpec := n.UrlUpstream()
+		add := []string{cmdRemote, "add"}
+		if co.SingleBranch {
+			add = append(add, "-t", branch)
+		}
+		fetch := []string{cmdFetch}
+		if co.Depth > 0 {
+			fetch = append(fetch, "--depth", strconv.Itoa(co.Depth))
+		}
+		if co.Filter != "" {
+			fetch = append(fetch, "--filter="+co.Filter)
+		}
+		p.steps = append(p.steps,
+			&step{args: append(add, remoteUpstream, fullSpec)},
+			&step{args: []string{
+				cmdRemote, "set-url", "--push", remoteUpstream,
+				"disableFootGun_" + fullSpec}},
+			// Fetch upstream now, so that status can compare to it.
+			&step{args: append(fetch, remoteUpstream)},
+		)
+		if !n.BranchPinned() {
+			// Record upstream's default branch, as clone does for origin.
+			p.steps = append(p.steps,
+				&step{args: []string{cmdRemote, "set-head", remoteUpstream, branch}})
+		}
+	}
+	p.steps = append(p.steps, &step{args: []string{cmdRemote, "-v"}})
+	p.setDefaultDir(n.AbsPath())
+	return p
+}
+
+// planRebase returns a plan to bring the repo's default branch
+// up to date.  The head is the checked out branch, or the commit
+// if HEAD is detached.  If it's the default branch, the plan
+// rebases it.  If not, the plan fast-forwards the default branch
+// without a checkout, and only if it can't, checks out the
+// default branch, rebases it, and checks out the head again.
+func planRebase(n *tree.RepoNode, branch, head string, strictPush bool) *plan {
+	remote := remoteOrigin
+	if n.IsAFork() {
+		remote = remoteUpstream
+	}
+	remoteBranch := path.Join(remote, branch)
+	p := &plan{
+		steps:   []*step{{args: []string{cmdFetch, remote}}},
+		outcome: RebasedTo,
+	}
+	if !n.BranchPinned() {
+		// Record the remote's default branch locally,
+		// e.g. for status, which never asks the remote.
+		p.steps = append(p.steps,
+			&step{args: []string{cmdRemote, "set-head", remote, branch}})
+	}
+	if head == branch {
+		p.steps = append(p.steps,
+			&step{args: []string{cmdDiff, remoteBranch}, stopIfSilent: true})
+		p.steps = append(p.steps, rebaseSteps(n, remote, remoteBranch)...)
+	} else {
+		rebase := rebaseSteps(n, remote, remoteBranch)
+		checkoutHead := &step{args: []string{cmdCheckout, head}}
+		for _, s := range rebase {
+			s.undo = []*step{checkoutHead}
+		}
+		last := rebase[len(rebase)-1]
+		last.errMsg = fmt.Sprintf(
+			"unable to rebase default branch %q; %q was checked out before", branch, head)
+		// The abort fails if the rebase failed to start.
+		last.undo = []*step{{args: []string{cmdRebase, "--abort"}, mayFail: true}, checkoutHead}
+		p.steps = append(p.steps,
+			&step{
+				args:         []string{cmdRevList, "--max-count=1", branch + ".." + remoteBranch},
+				stopIfSilent: true,
+			},
+			&step{
+				// Fetching from "." updates a local branch,
+				// but only if that's a fast-forward.
+				args: []string{cmdFetch, ".", remoteBranch + ":" + branch},
+				fallback: append(append(
+					[]*step{{
+						args: []string{cmdCheckout, branch},
+						errMsg: fmt.Sprintf(
+							"unable to checkout default branch %q", branch),
+					}},
+					rebase...),
+					checkoutHead),
+			})
+	}
+	if n.IsAFork() && !n.NoPush() {
+		p.push = pushSteps(branch, strictPush)
+	}
+	p.setDefaultDir(n.AbsPath())
+	return p
+}
+
+// pushSteps are the steps that push a fork's default branch to
+// origin, if origin lacks some of its commits, e.g. because an
+// earlier push failed.  The push is forced, since rebasing
+// rewrites the branch, but it's leased, so that it fails if
+// origin has changed since it was last fetched or pushed to.
+// If strict, origin is fetched first, and the push is refused
+// if origin has commits that aren't in upstream.
+func pushSteps(branch string, strict bool) []*step {
+	originBranch := path.Join(remoteOrigin, branch)
+	var steps []*step
+	if strict {
+		steps = append(steps,
+			&step{args: []string{cmdFetch, remoteOrigin, branch}},
+			&step{
+				args: []string{cmdLog, "--oneline", "--no-decorate",
+					path.Join(remoteUpstream, branch) + ".." + originBranch},
+				refuseIfOutput: true,
+			})
+	}
+	return append(steps,
+		&step{
+			args:         []string{cmdRevList, "--max-count=1", originBranch + ".." + branch},
+			stopIfSilent: true,
+		},
+		&step{args: []string{
+			cmdPush, "--force-with-lease=" + branch + ":refs/remotes/" + originBranch,
+			remoteOrigin, branch}})
+}
+
+// rebaseSteps are the steps that rebase the checked out
+// default branch onto the remote branch.
+func rebaseSteps(n *tree.RepoNode, remote, remoteBranch string) []*step {
+	var steps []*step
+	if n.Clone.Depth > 0 {
+		// A shallow clone might lack the history
+		// the rebase needs to find the fork point.
+		steps = append(steps, &step{
+			args:       []string{cmdMergeBase, "HEAD", remoteBranch},
+			deepenFrom: remote,
+		})
+	}
+	return append(steps, &step{args: []string{cmdRebase, remoteBranch}})
+}
+
+// pinnedMismatch describes a pinned default branch
+// that isn't the remote's default branch.
+func pinnedMismatch(pinned, remote string) string {
+	return fmt.Sprintf(
+		"pinned default branch %q isn't the remote's default branch %q", pinned, remote)
+}
+
+// setDefaultDir sets the working directory of steps lacking one.
+func (p *plan) setDefaultDir(d file.Path) {
+	setDefaultDir(append(p.steps, p.push...), d)
+}
+
+func setDefaultDir(steps []*step, d file.Path) {
+	for _, s := range steps {
+		if s.dir == "" {
+			s.dir = d
+		}
+		setDefaultDir(s.fallback, d)
+		setDefaultDir(s.undo, d)
+	}
+}
+
+// Planner prints what Cloner would do, without doing it.
+type Planner struct {
+	walker
+	opts UpdateOpts
+}
+
+// NewPlanner returns a Planner for a Cloner with the given
+// options, that plans at most the given number of repos at once.
+// Planning asks remotes for their default branches, so canceling
+// the context stops it.
+func NewPlanner(ctx context.Context, jobs int, opts UpdateOpts) *Planner {
+	return &Planner{walker: newTextWalker(ctx, jobs), opts: opts}
+}
+
+func (v *Planner) VisitRepoNode(n *tree.RepoNode) {
+	v.addRepo(n, func() func() {
+		g, err := newGitRepo(v.ctx, n)
+		var p *plan
+		if err == nil {
+			p, err = planFor(g, v.opts)
+		}
+		return func() {
+			if err != nil {
+				v.reportErr(n, err)
+				return
+			}
+			label, where := "would rebase", "in "
+			if p.outcome == ClonedAt {
+				label, where = "would clone", "into "
+			}
+			reportStatus(n, color.Blue+label+color.Reset, where+string(n.AbsPath()))
+			if p.remoteDefault != "" {
+				fmt.Println(indent(4) + "# " + pinnedMismatch(p.branch, p.remoteDefault))
+			}
+			if p.mkDir != "" {
+				fmt.Println(indent(4) + "mkdir -p " + quoteIfNeeded(string(p.mkDir)))
+			}
+			if p.stash != StashNone {
+				s := &step{dir: n.AbsPath(), args: p.stash.pushArgs()}
+				fmt.Println(indent(4) + s.String() + "   # if there are local changes")
+			}
+			printSteps(4, p.steps)
+			if len(p.push) > 0 {
+				fmt.Println(indent(4) + "# then, even if there was no update, push to origin:")
+				printSteps(5, p.push)
+			}
+			for _, b := range p.branches {
+				s := &step{dir: n.AbsPath(), args: []string{cmdRebase, p.branch, b}}
+				fmt.Println(indent(4) + s.String() + "   # aborted if it fails")
+			}
+			if len(p.branches) > 0 {
+				s := &step{dir: n.AbsPath(), args: []string{cmdCheckout, p.head}}
+				fmt.Println(indent(4) + s.String())
+			}
+			if p.stash != StashNone {
+				s := &step{dir: n.AbsPath(), args: []string{cmdStash, "pop"}}
+				fmt.Println(indent(4) + s.String() + "   # if changes were stashed")
+			}
+			name, hooks := hooksFor(n, p.outcome)
+			for _, h := range hooks {
+				fmt.Println(indent(4) + shellProgram + " -c " + quoteIfNeeded(h) +
+					"   # " + name + " hook in " + quoteIfNeeded(string(n.AbsPath())))
+			}
+		}
+	})
+}
+
+// printSteps prints the steps at the given depth, with their
+// fallback and undo steps below them.
+func printSteps(depth int, steps []*step) {
+	for _, s := range steps {
+		fmt.Println(indent(depth) + s.String())
+		if len(s.fallback) > 0 {
+			fmt.Println(indent(depth) + "# if that fails:")
+			printSteps(depth+1, s.fallback)
+		}
+		if len(s.undo) > 0 {
+			fmt.Println(indent(depth) + "# if that fails, before giving up:")
+			printSteps(depth+1, s.undo)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package visitor
+
+import "sync"
+
+// task does some work, and returns a function that reports it.
+type task func() (report func())
+
+// entry is an element of the pool's ordered report list.
+type entry struct {
+	task   task
+	report func()
+	ready  bool
+}
+
+// pool runs tasks on a fixed number of goroutines.
+//
+// Tasks start in the order they were added, and their reports
+// run in that same order, one at a time, as soon as all the
+// reports ahead of them have run.  Since reports never run
+// concurrently, they may safely update state shared with other
+// reports.
+type pool struct {
+	mu      sync.Mutex
+	entries []*entry
+	next    int
+	tasks   chan *entry
+	wg      sync.WaitGroup
+}
+
+func newPool(size int) *pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &pool{tasks: make(chan *entry)}
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *pool) work() {
+	for e := range p.tasks {
+		r := e.task()
+		p.mu.Lock()
+		e.report, e.ready = r, true
+		p.flush()
+		p.mu.Unlock()
+		p.wg.Done()
+	}
+}
+
+// add schedules a task, blocking until a goroutine is free to run it.
+func (p *pool) add(t task) {
+	e := &entry{task: t}
+	p.mu.Lock()
+	p.entries = append(p.entries, e)
+	p.mu.Unlock()
+	p.wg.Add(1)
+	p.tasks <- e
+}
+
+// addReport adds a report that needs no work to produce.
+func (p *pool) addReport(r func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries = append(p.entries, &entry{report: r, ready: true})
+	p.flush()
+}
+
+// flush runs the ready reports at the front of the list.
+// The caller must hold the lock.
+func (p *pool) flush() {
+	for p.next < len(p.entries) && p.entries[p.next].ready {
+		if r := p.entries[p.next].report; r != nil {
+			r()
+		}
+		// Let the entry's memory go.
+		p.entries[p.next] = nil
+		p.next++
+	}
+}
+
+// wait blocks until every task has finished and been reported,
+// then stops the goroutines.  Don't add to the pool after this.
+func (p *pool) wait() {
+	p.wg.Wait()
+	close(p.tasks)
+}
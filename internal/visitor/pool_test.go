@@ -0,0 +1,25 @@
+package visitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolReportsInOrder(t *testing.T) {
+	var got []int
+	p := newPool(4)
+	p.addReport(func() { got = append(got, 0) })
+	for i := 1; i <= 8; i++ {
+		i := i
+		p.add(func() func() {
+			// Make the early tasks finish last.
+			time.Sleep(time.Duration(10-i) * time.Millisecond)
+			return func() { got = append(got, i) }
+		})
+	}
+	p.addReport(func() { got = append(got, 9) })
+	p.wait()
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+}
@@ -2,6 +2,8 @@ package visitor
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/monopole/myrepos/internal/tree"
 )
 
@@ -19,9 +21,21 @@ func (v *Printer) VisitServerNode(n *tree.ServerNode) {
 
 func (v *Printer) VisitOrgNode(n *tree.OrgNode) {
 	fmt.Print(indent(2))
-	fmt.Printf("%s|%s|%s\n", n.NameDir(), n.NameOrigin(), n.NameUpstream())
+	fmt.Printf("%s|%s|%s%s\n", n.NameDir(), n.NameOrigin(), n.NameUpstream(), tagList(n.Tags()))
 }
 
 func (v *Printer) VisitRepoNode(n *tree.RepoNode) {
-	fmt.Println(indent(3), n.Name)
+	name := n.Name
+	if string(n.NameDir()) != n.Name {
+		name = string(n.NameDir()) + " (" + n.Name + ")"
+	}
+	fmt.Println(indent(3), name+tagList(n.Tags()))
+}
+
+// tagList formats tags for printing after a name.
+func tagList(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "  [" + strings.Join(tags, ", ") + "]"
 }
@@ -0,0 +1,101 @@
+package visitor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// Stash says which local changes to stash while updating a repo.
+type Stash int
+
+const (
+	// StashNone leaves local changes alone, so updating
+	// a repo with changes fails.
+	StashNone Stash = iota
+	// StashTracked stashes changes to tracked files.
+	StashTracked
+	// StashAll stashes untracked files too.
+	StashAll
+)
+
+const (
+	cmdStash = "stash"
+	// stashMessage labels the stashes that myrepos makes.
+	stashMessage = "myrepos autostash"
+	// stashMarker is the file, in a repo's .git directory,
+	// left when restoring stashed changes conflicts.
+	stashMarker = "MYREPOS_STASH_CONFLICT"
+)
+
+// pushArgs are the git arguments that stash the changes.
+func (s Stash) pushArgs() []string {
+	args := []string{cmdStash, "push", "-m", stashMessage}
+	if s == StashAll {
+		args = append(args, "--include-untracked")
+	}
+	return args
+}
+
+// statusArgs are the git arguments that list the changes to stash.
+func (s Stash) statusArgs() []string {
+	args := []string{cmdStatus, "--porcelain"}
+	if s != StashAll {
+		args = append(args, "--untracked-files=no")
+	}
+	return args
+}
+
+// stashMarkerPath returns the path of the repo's stash conflict marker.
+func stashMarkerPath(n *tree.RepoNode) file.Path {
+	return n.AbsPath().Append(".git").Append(stashMarker)
+}
+
+// errIfStashConflict returns an error if an earlier update left
+// a stash conflict marker, since the conflict needs a person.
+func errIfStashConflict(n *tree.RepoNode) error {
+	p := stashMarkerPath(n)
+	if exists, _ := p.Exists(); exists {
+		return fmt.Errorf(
+			"restoring stashed changes conflicted earlier; resolve that, then remove %s", p)
+	}
+	return nil
+}
+
+// stash stashes the local changes, if there are any,
+// returning true if it made a stash.
+func (g *gitRepo) stash(s Stash) (bool, error) {
+	if err := g.gr.Run(s.statusArgs()...); err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(g.gr.GetOutput()) == "" {
+		return false, nil
+	}
+	if err := g.gr.Run(s.pushArgs()...); err != nil {
+		return false, fmt.Errorf("unable to stash local changes; %w", err)
+	}
+	return true, nil
+}
+
+// unstash restores the stashed changes.  If that conflicts, git
+// keeps the stash, and unstash leaves a marker saying so.
+func (g *gitRepo) unstash() error {
+	err := g.gr.Run(cmdStash, "pop")
+	if err == nil {
+		return nil
+	}
+	p := stashMarkerPath(g.n)
+	msg := fmt.Sprintf(
+		"%s: restoring the changes stashed by myrepos conflicted.\n"+
+			"They're still in 'git stash list'.  Resolve the conflict,\n"+
+			"run 'git stash drop' and remove this file.\n%v\n",
+		time.Now().Format(time.RFC3339), err)
+	if wErr := os.WriteFile(string(p), []byte(msg), 0o644); wErr != nil {
+		return fmt.Errorf("%w; also unable to write %s; %v", err, p, wErr)
+	}
+	return fmt.Errorf("restoring stashed changes conflicted; see %s", p)
+}
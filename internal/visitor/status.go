@@ -0,0 +1,217 @@
+package visitor
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/TwiN/go-color"
+	"github.com/monopole/myrepos/internal/runner"
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// Inspector reports the state of each repo's local clone.
+// It never fetches, and never changes a working tree, so
+// it's safe to run at any time.
+type Inspector struct {
+	walker
+}
+
+// NewInspector returns an Inspector that works on at most
+// the given number of repos at once.
+func NewInspector(ctx context.Context, jobs int) *Inspector {
+	return &Inspector{walker: newTextWalker(ctx, jobs)}
+}
+
+func (v *Inspector) VisitRepoNode(n *tree.RepoNode) {
+	v.addRepo(n, func() func() {
+		s, err := Inspect(v.ctx, n)
+		return func() {
+			if err != nil {
+				v.reportErr(n, err)
+				return
+			}
+			reportStatus(n, s.label(), s.String())
+		}
+	})
+}
+
+// Divergence counts the commits on either side of a comparison
+// between the local HEAD and some remote branch.
+type Divergence struct {
+	// Remote is the remote branch compared to, e.g. origin/main.
+	Remote string
+	// Ahead is the number of commits in HEAD but not in Remote.
+	Ahead int
+	// Behind is the number of commits in Remote but not in HEAD.
+	Behind int
+	// NotFetched is true if the clone lacks Remote, e.g. if the
+	// remote hasn't been fetched yet, so there are no counts.
+	NotFetched bool
+}
+
+func (d *Divergence) String() string {
+	if d.NotFetched {
+		return d.Remote + " not fetched"
+	}
+	return fmt.Sprintf("%s +%d -%d", d.Remote, d.Ahead, d.Behind)
+}
+
+// RepoStatus is a snapshot of the state of a local clone.
+type RepoStatus struct {
+	// Missing is true if the repo hasn't been cloned.
+	Missing bool
+	// Branch is the checked out branch, or a short
+	// commit hash in parentheses if HEAD is detached.
+	Branch string
+	// Changed counts tracked files with changes.
+	Changed int
+	// Untracked counts files unknown to git.
+	Untracked int
+	// Divergences holds comparisons with origin, and with
+	// upstream if the repo is a fork.
+	Divergences []*Divergence
+	// StashConflict is true if restoring stashed changes
+	// conflicted in an earlier update, and isn't yet resolved.
+	StashConflict bool
+}
+
+func (s *RepoStatus) label() string {
+	switch {
+	case s.Missing:
+		return color.Red + "missing" + color.Reset
+	case s.StashConflict:
+		return color.Red + "stash conflict" + color.Reset
+	case s.Changed > 0 || s.Untracked > 0:
+		return color.Yellow + "dirty" + color.Reset
+	default:
+		return color.Green + "clean" + color.Reset
+	}
+}
+
+func (s *RepoStatus) String() string {
+	if s.Missing {
+		return "not cloned"
+	}
+	parts := []string{"on " + s.Branch}
+	if s.Changed > 0 {
+		parts = append(parts, strconv.Itoa(s.Changed)+" changed")
+	}
+	if s.Untracked > 0 {
+		parts = append(parts, strconv.Itoa(s.Untracked)+" untracked")
+	}
+	for _, d := range s.Divergences {
+		parts = append(parts, d.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Inspect returns the status of the repo's local clone.
+// It only runs git commands that don't change the clone.
+func Inspect(ctx context.Context, n *tree.RepoNode) (*RepoStatus, error) {
+	exists, isDir := n.AbsPath().Exists()
+	if !exists {
+		return &RepoStatus{Missing: true}, nil
+	}
+	if !isDir {
+		return nil, fmt.Errorf("%q exists but isn't a directory", n.AbsPath())
+	}
+	gr, err := runner.NewRunner(ctx, gitProgram, n.ServerSpec().Timeout(), commonErrs)
+	if err != nil {
+		return nil, err
+	}
+	gr.SetPwd(n.AbsPath())
+	var s RepoStatus
+	s.StashConflict, _ = stashMarkerPath(n).Exists()
+	if s.Branch, err = currentBranch(gr); err != nil {
+		return nil, err
+	}
+	// Without --no-optional-locks, 'git status' might refresh the index.
+	if err = gr.Run(optNoLocks, cmdStatus, "--porcelain"); err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(gr.GetOutput(), "\n") {
+		if strings.HasPrefix(line, "??") {
+			s.Untracked++
+		} else if len(line) > 0 {
+			s.Changed++
+		}
+	}
+	remotes := []string{remoteOrigin}
+	if n.IsAFork() {
+		remotes = append(remotes, remoteUpstream)
+	}
+	branch := localDefaultBranch(gr, n, remotes[len(remotes)-1])
+	if branch == "" {
+		// Nothing says what to compare to.
+		s.Divergences = []*Divergence{{
+			Remote: path.Join(remotes[len(remotes)-1], "HEAD"), NotFetched: true}}
+		return &s, nil
+	}
+	for _, r := range remotes {
+		var d *Divergence
+		if d, err = divergence(gr, path.Join(r, branch)); err != nil {
+			return nil, err
+		}
+		s.Divergences = append(s.Divergences, d)
+	}
+	return &s, nil
+}
+
+// localDefaultBranch returns the repo's default branch, as the
+// clone last recorded it for the remote if it isn't pinned, or
+// "" if the clone hasn't recorded it.
+func localDefaultBranch(gr *runner.Runner, n *tree.RepoNode, remote string) string {
+	if n.BranchPinned() {
+		return n.DefaultBranch
+	}
+	if gr.Run("symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD") == nil {
+		if b := strings.TrimSpace(gr.GetOutput()); strings.HasPrefix(b, remote+"/") {
+			return strings.TrimPrefix(b, remote+"/")
+		}
+	}
+	return ""
+}
+
+// currentBranch returns the name of the checked out branch, or
+// a short commit hash in parentheses if HEAD is detached.
+func currentBranch(gr *runner.Runner) (string, error) {
+	if err := gr.Run(cmdRevParse, "--abbrev-ref", "HEAD"); err != nil {
+		return "", err
+	}
+	b := strings.TrimSpace(gr.GetOutput())
+	if b != "HEAD" {
+		return b, nil
+	}
+	if err := gr.Run(cmdRevParse, "--short", "HEAD"); err != nil {
+		return "", err
+	}
+	return "(" + strings.TrimSpace(gr.GetOutput()) + ")", nil
+}
+
+// divergence compares HEAD to the given remote branch, as of
+// the most recent fetch.
+func divergence(gr *runner.Runner, remote string) (*Divergence, error) {
+	if gr.Run(cmdRevParse, "--verify", "--quiet", "refs/remotes/"+remote) != nil {
+		return &Divergence{Remote: remote, NotFetched: true}, nil
+	}
+	if err := gr.Run(cmdRevList, "--left-right", "--count", "HEAD..."+remote); err != nil {
+		return nil, fmt.Errorf("unable to compare HEAD to %s; %w", remote, err)
+	}
+	counts := strings.Fields(gr.GetOutput())
+	if len(counts) != 2 {
+		return nil, fmt.Errorf(
+			"unexpected rev-list output comparing HEAD to %s: %q", remote, gr.GetOutput())
+	}
+	d := &Divergence{Remote: remote}
+	var err error
+	if d.Ahead, err = strconv.Atoi(counts[0]); err != nil {
+		return nil, err
+	}
+	if d.Behind, err = strconv.Atoi(counts[1]); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,75 @@
+package visitor_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/monopole/myrepos/internal/config"
+	"github.com/monopole/myrepos/internal/tree"
+	. "github.com/monopole/myrepos/internal/visitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// inspect returns the status of the config's only repo.
+func inspect(t *testing.T, c *config.Config) (*RepoStatus, error) {
+	rn, err := tree.MakeRootNode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v repoGetter
+	rn.Accept(&v)
+	return Inspect(context.Background(), v.repo)
+}
+
+// repoGetter remembers the last repo visited.
+type repoGetter struct {
+	repo *tree.RepoNode
+}
+
+func (v *repoGetter) VisitRootNode(*tree.RootNode)     {}
+func (v *repoGetter) VisitServerNode(*tree.ServerNode) {}
+func (v *repoGetter) VisitOrgNode(*tree.OrgNode)       {}
+func (v *repoGetter) VisitRepoNode(n *tree.RepoNode)   { v.repo = n }
+
+func TestInspectUnfetchedRemote(t *testing.T) {
+	dir := gitSandbox(t)
+	makeFork(t, dir, "monopole", "kubernetes-sigs", "kustomize")
+	c := sandboxConfig(dir, "k8s|monopole|kubernetes-sigs",
+		config.RepoSpec{Name: "kustomize", Branch: "main"})
+	_, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+	// Forget what upstream has.
+	clone := filepath.Join(dir, "root", "github.com", "k8s", "kustomize")
+	url := git(t, clone, "remote", "get-url", "upstream")
+	git(t, clone, "remote", "remove", "upstream")
+	git(t, clone, "remote", "add", "upstream", url)
+
+	s, err := inspect(t, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "on main, origin/main +0 -0, upstream/main not fetched", s.String())
+}
+
+func TestInspectUnpinnedForkAfterClone(t *testing.T) {
+	dir := gitSandbox(t)
+	makeFork(t, dir, "monopole", "kubernetes-sigs", "kustomize")
+	for _, org := range []string{"monopole", "kubernetes-sigs"} {
+		bare := filepath.Join(dir, "remotes", org, "kustomize.git")
+		git(t, bare, "branch", "develop", "main")
+		git(t, bare, "symbolic-ref", "HEAD", "refs/heads/develop")
+	}
+	c := sandboxConfig(dir, "k8s|monopole|kubernetes-sigs", config.RepoSpec{Name: "kustomize"})
+	_, err := update(t, c, UpdateOpts{})
+	assert.NoError(t, err)
+
+	s, err := inspect(t, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "on develop, origin/develop +0 -0, upstream/develop +0 -0", s.String())
+
+	// Without a record of upstream's default branch, don't guess.
+	clone := filepath.Join(dir, "root", "github.com", "k8s", "kustomize")
+	git(t, clone, "remote", "set-head", "upstream", "--delete")
+	s, err = inspect(t, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "on develop, upstream/HEAD not fetched", s.String())
+}
@@ -0,0 +1,133 @@
+package visitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/monopole/myrepos/internal/tree"
+)
+
+// walker holds what's common to visitors that do some work on
+// each repo, possibly concurrently.  It sends a header to its
+// output for each root, server and org node, and leaves repo
+// nodes to the embedding visitor.
+type walker struct {
+	ctx      context.Context
+	jobs     int
+	out      Output
+	pool     *pool
+	lastErr  error
+	fatalErr error
+}
+
+func (v *walker) fatal(e error) {
+	v.fatalErr = e
+}
+
+// Err waits for all repos to finish, then returns the error that
+// stopped the walk, if any, else the last error from some repo.
+func (v *walker) Err() error {
+	if v.pool != nil {
+		v.pool.wait()
+		v.pool = nil
+	}
+	if v.fatalErr != nil {
+		return v.fatalErr
+	}
+	return v.lastErr
+}
+
+func (v *walker) VisitRootNode(n *tree.RootNode) {
+	if v.fatalErr != nil {
+		return
+	}
+	if err := errIfBadRootDir(n); err != nil {
+		v.fatal(err)
+		return
+	}
+	v.pool = newPool(v.jobs)
+	v.pool.addReport(func() { v.out.Header(0, string(n.AbsPath())) })
+}
+
+func (v *walker) VisitServerNode(n *tree.ServerNode) {
+	// TODO: Use some simple git command, e.g.
+	//  git remote show origin
+	// to comfirm that the server is reachable.
+	if v.fatalErr != nil {
+		return
+	}
+	if exists, isDir := n.AbsPath().Exists(); exists && !isDir {
+		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
+		return
+	}
+	v.pool.addReport(func() { v.out.Header(1, string(n.Domain())) })
+}
+
+func (v *walker) VisitOrgNode(n *tree.OrgNode) {
+	if v.fatalErr != nil {
+		return
+	}
+	if exists, isDir := n.AbsPath().Exists(); exists && !isDir {
+		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
+		return
+	}
+	v.pool.addReport(func() { v.out.Header(2, string(n.NameDir())) })
+}
+
+// addRepo schedules work on a repo, unless the walk has stopped.
+func (v *walker) addRepo(n *tree.RepoNode, t task) {
+	if v.fatalErr != nil {
+		return
+	}
+	if err := v.ctx.Err(); err != nil {
+		v.fatal(err)
+		return
+	}
+	if exists, isDir := n.AbsPath().Exists(); exists && !isDir {
+		v.fatal(fmt.Errorf("%q exists but isn't a directory", n.AbsPath()))
+		return
+	}
+	v.pool.add(t)
+}
+
+// reportErr records and prints a repo error.
+// Only call this from a report.
+func (v *walker) reportErr(n *tree.RepoNode, err error) {
+	v.lastErr = err
+	reportStatus(n, Oops.String(), err.Error())
+}
+
+// reportStatus prints a line about the repo.
+func reportStatus(n *tree.RepoNode, outcome string, status string) {
+	fmt.Printf(fmtReport, n.Name, outcome, status)
+}
+
+func newTextWalker(ctx context.Context, jobs int) walker {
+	return walker{ctx: ctx, jobs: jobs, out: &textOutput{w: os.Stdout}}
+}
+
+// errIfBadRootDir returns an error if the root path isn't an existing directory.
+func errIfBadRootDir(n *tree.RootNode) error {
+	exists, isDir := n.AbsPath().Exists()
+	if !exists {
+		// Make it for instead of complain?
+		// Could trigger a bunch of work if it's just a typo.
+		return fmt.Errorf("if you want the root dir %s, make it first", describeRoot(n))
+	}
+	if !isDir {
+		return fmt.Errorf("%s exists but isn't a directory", describeRoot(n))
+	}
+	return nil
+}
+
+// describeRoot quotes the root path, and the path written in
+// the config if it differs, e.g. if it holds variables.
+func describeRoot(n *tree.RootNode) string {
+	abs, raw := n.AbsPath(), n.RawPath()
+	if raw == "" || raw == abs {
+		return strconv.Quote(string(abs))
+	}
+	return fmt.Sprintf("%q (from %q)", abs, raw)
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"github.com/monopole/myrepos/internal/config"
 	"github.com/monopole/myrepos/internal/file"
+	"github.com/monopole/myrepos/internal/importer"
 	"github.com/monopole/myrepos/internal/ssh"
 	"github.com/monopole/myrepos/internal/tree"
 	"github.com/monopole/myrepos/internal/visitor"
@@ -14,74 +19,458 @@ import (
 )
 
 const (
-	version   = "v0.2.2"
-	shortHelp = "Clone or rebase the repositories specified in the input file."
+	version      = "v0.2.2"
+	shortHelp    = "Clone or rebase the repositories specified in the input file."
+	statusHelp   = "Report the state of the local clones without changing them."
+	orphansHelp  = "List directories below the root that aren't in the config."
+	importHelp   = "Write a config file describing existing clones."
+	execHelp     = "Run a command in every configured repo."
+	printHelp    = "Print the tree of repos described by the config."
+	validateHelp = "Check config files for problems."
 )
 
+// sharedFlags holds the flags common to all commands that walk trees.
+type sharedFlags struct {
+	jobs   int
+	filter tree.Filter
+	// merge means merge all the config files into one.
+	merge bool
+}
+
+// load loads the config files, merging them into one if asked.
+func (f *sharedFlags) load(paths []file.Path) ([]*config.Config, error) {
+	if f.merge {
+		c, err := config.Load(paths...)
+		if err != nil {
+			return nil, err
+		}
+		return []*config.Config{c}, nil
+	}
+	result := make([]*config.Config, len(paths))
+	for i := range paths {
+		c, err := config.Load(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = c
+	}
+	return result, nil
+}
+
+// configArgs returns a cobra.PositionalArgs that loads the config
+// files named in the arguments into cfg.
+func (f *sharedFlags) configArgs(cfg *[]*config.Config) cobra.PositionalArgs {
+	return func(_ *cobra.Command, args []string) error {
+		paths, err := file.GetFilePath(args)
+		if err != nil {
+			return err
+		}
+		*cfg, err = f.load(paths)
+		return err
+	}
+}
+
+// makeTree returns the config's tree, holding just the
+// repos selected by the filter.
+func (f *sharedFlags) makeTree(c *config.Config) (*tree.RootNode, error) {
+	t, err := tree.MakeRootNode(c)
+	if err != nil {
+		return nil, err
+	}
+	if !f.filter.IsEmpty() {
+		t.Prune(f.filter.Keep)
+	}
+	return t, nil
+}
+
+// visitAll makes a tree from each config, and visits it with
+// a new visitor, stopping at the first error.
+func (f *sharedFlags) visitAll(cfg []*config.Config, newVisitor func() errVisitor) error {
+	for i := range cfg {
+		t, err := f.makeTree(cfg[i])
+		if err != nil {
+			return err
+		}
+		v := newVisitor()
+		t.Accept(v)
+		if err = v.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func newCommand() *cobra.Command {
-	var cfg []*config.Config
-	return &cobra.Command{
+	var (
+		cfg    []*config.Config
+		flags  sharedFlags
+		dryRun bool
+		format string
+		// autostash and untracked say what to stash around updates.
+		autostash bool
+		untracked bool
+		// rebaseBranches and branchPatterns pick local
+		// branches to rebase onto the updated default branch.
+		rebaseBranches bool
+		branchPatterns []string
+		strictPush     bool
+	)
+	c := &cobra.Command{
 		Use:   "myrepos [{configFile}]",
 		Short: shortHelp,
 		Long:  shortHelp + " " + version,
 		Example: "  myrepos " + file.DefaultConfigFileName() + `
 
   If the config file argument has the default value shown above,
-  then the argument can be omitted.`,
-		Args: func(_ *cobra.Command, args []string) error {
-			paths, err := file.GetFilePath(args)
+  then the argument can be omitted.  Otherwise, without arguments,
+  the config is $MYREPOS_CONFIG, or $XDG_CONFIG_HOME/myrepos, or
+  ~/.myrepos.yml.  A directory argument loads all its *.yml files
+  (and those in its conf.d) as one config.`,
+		Args: flags.configArgs(&cfg),
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			return flags.filter.Validate()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var opts visitor.UpdateOpts
+			switch {
+			case untracked:
+				opts.Stash = visitor.StashAll
+			case autostash:
+				opts.Stash = visitor.StashTracked
+			}
+			if err := visitor.ValidateBranchPatterns(branchPatterns); err != nil {
+				return err
+			}
+			opts.RebaseBranches = rebaseBranches || len(branchPatterns) > 0
+			opts.BranchPatterns = branchPatterns
+			opts.StrictPush = strictPush
+			if dryRun {
+				return flags.visitAll(cfg, func() errVisitor {
+					return visitor.NewPlanner(cmd.Context(), flags.jobs, opts)
+				})
+			}
+			if err := ssh.ErrIfNoSshAgent(); err != nil {
+				return err
+			}
+			if err := ssh.ErrIfNoSshKeys(); err != nil {
+				return err
+			}
+			out, err := visitor.NewOutput(format, os.Stdout)
 			if err != nil {
 				return err
 			}
-			for i := range paths {
-				var c *config.Config
-				c, err = loadConfig(paths[i])
+			err = flags.visitAll(cfg, func() errVisitor {
+				return visitor.NewCloner(cmd.Context(), flags.jobs, out, opts)
+			})
+			if cErr := out.Close(); err == nil {
+				err = cErr
+			}
+			return err
+		},
+		SilenceUsage: true,
+	}
+	c.Flags().BoolVar(
+		&dryRun, "dry-run", false,
+		"print the git commands each repo would get, without running them")
+	c.Flags().BoolVar(
+		&autostash, "autostash", false,
+		"stash changes to tracked files before updating a repo, and restore them after")
+	c.Flags().BoolVar(
+		&untracked, "autostash-untracked", false,
+		"like --autostash, but stash untracked files too")
+	c.Flags().BoolVar(
+		&rebaseBranches, "rebase-branches", false,
+		"after updating a repo's default branch, rebase the local branches tracking it onto it")
+	c.Flags().StringSliceVar(
+		&branchPatterns, "branch-pattern", nil,
+		"like --rebase-branches, also rebasing local branches matching these glob patterns")
+	c.Flags().BoolVar(
+		&strictPush, "strict-push", false,
+		"don't push a fork's default branch to origin if origin has commits "+
+			"that aren't in upstream; list them instead")
+	c.Flags().StringVarP(
+		&format, "output", "o", visitor.FormatText,
+		"how to write results: "+visitor.FormatText+", "+
+			visitor.FormatJson+" or "+visitor.FormatYaml)
+	c.PersistentFlags().IntVarP(
+		&flags.jobs, "jobs", "j", 1, "how many repos to work on at once")
+	c.PersistentFlags().StringSliceVar(
+		&flags.filter.Only, "only", nil,
+		"work only on repos matching these {server}/{org}/{repo} glob patterns")
+	c.PersistentFlags().StringSliceVar(
+		&flags.filter.Skip, "skip", nil,
+		"skip repos matching these {server}/{org}/{repo} glob patterns")
+	c.PersistentFlags().StringSliceVar(
+		&flags.filter.Groups, "group", nil,
+		"work only on repos having one of these tags")
+	c.PersistentFlags().BoolVar(
+		&flags.merge, "merge", false,
+		"merge the config files into one, rather than using each in turn")
+	c.AddCommand(newStatusCommand(&flags))
+	c.AddCommand(newPrintCommand(&flags))
+	c.AddCommand(newOrphansCommand(&flags))
+	c.AddCommand(newImportCommand())
+	c.AddCommand(newExecCommand(&flags))
+	c.AddCommand(newValidateCommand(&flags))
+	return c
+}
+
+func newStatusCommand(flags *sharedFlags) *cobra.Command {
+	var cfg []*config.Config
+	return &cobra.Command{
+		Use:   "status [{configFile}]",
+		Short: statusHelp,
+		Long: statusHelp + `
+
+  Reports each repo's current branch, counts of changed and untracked
+  files, and how far HEAD is ahead of or behind the default branch on
+  origin (and upstream, for forks) as of the most recent fetch.
+  Nothing is fetched, and no working tree is modified.`,
+		Args: flags.configArgs(&cfg),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return flags.visitAll(cfg, func() errVisitor {
+				return visitor.NewInspector(cmd.Context(), flags.jobs)
+			})
+		},
+		SilenceUsage: true,
+	}
+}
+
+func newPrintCommand(flags *sharedFlags) *cobra.Command {
+	var cfg []*config.Config
+	return &cobra.Command{
+		Use:   "print [{configFile}]",
+		Short: printHelp,
+		Args:  flags.configArgs(&cfg),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for i := range cfg {
+				t, err := flags.makeTree(cfg[i])
 				if err != nil {
 					return err
 				}
-				cfg = append(cfg, c)
+				v := visitor.Printer{}
+				t.Accept(&v)
+				if v.Err != nil {
+					return v.Err
+				}
 			}
-			return err
+			return nil
 		},
+		SilenceUsage: true,
+	}
+}
+
+func newOrphansCommand(flags *sharedFlags) *cobra.Command {
+	var (
+		cfg        []*config.Config
+		quarantine string
+	)
+	c := &cobra.Command{
+		Use:   "orphans [{configFile}]",
+		Short: orphansHelp,
+		Long: orphansHelp + `
+
+  Walks the directories below the root path at the server, org and
+  repo depth, and lists each one that isn't in the layout of any of
+  the given config files.  Directories just below the root that
+  don't hold git repos at the org/repo depth are ignored, since the
+  root is often shared with other things.`,
+		Args: flags.configArgs(&cfg),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := ssh.ErrIfNoSshAgent(); err != nil {
+			v := visitor.NewOrphanFinder()
+			for i := range cfg {
+				t, err := tree.MakeRootNode(cfg[i])
+				if err != nil {
+					return err
+				}
+				t.Accept(v)
+			}
+			var skip []file.Path
+			if quarantine != "" {
+				q, err := filepath.Abs(quarantine)
+				if err != nil {
+					return err
+				}
+				skip = append(skip, file.Path(q))
+			}
+			orphans, err := v.Orphans(skip...)
+			if err != nil {
 				return err
 			}
-			if err := ssh.ErrIfNoSshKeys(); err != nil {
+			for _, o := range orphans {
+				if quarantine == "" {
+					fmt.Println(o)
+					continue
+				}
+				var target file.Path
+				if target, err = o.MoveTo(skip[0]); err != nil {
+					return err
+				}
+				fmt.Printf("moved %s to %s\n", o.Path, target)
+			}
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	c.Flags().StringVar(
+		&quarantine, "quarantine", "",
+		"move orphans into this directory, rather than just listing them")
+	return c
+}
+
+func newImportCommand() *cobra.Command {
+	var outFile, rootPath string
+	c := &cobra.Command{
+		Use:   "import {dir}",
+		Short: importHelp,
+		Long: importHelp + `
+
+  Finds git repositories below the given directory, reads their
+  'origin' and 'upstream' remote urls and default branches, and
+  writes a config file with the corresponding layout.  Repos that
+  cannot be described in a config (e.g. those with unusual remote
+  urls) are listed on stderr.`,
+		Example: "  myrepos import ~/src --path myrepos --out " +
+			file.DefaultConfigFileName(),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := file.Path(args[0])
+			if _, isDir := dir.Exists(); !isDir {
+				return fmt.Errorf("%q isn't a directory", dir)
+			}
+			c, unmapped, err := importer.Import(cmd.Context(), dir)
+			if err != nil {
+				return err
+			}
+			c.Path = file.Path(rootPath)
+			for _, u := range unmapped {
+				fmt.Fprintln(os.Stderr, "unmapped "+u.String())
+			}
+			w := os.Stdout
+			if outFile != "" {
+				if exists, _ := file.Path(outFile).Exists(); exists {
+					return fmt.Errorf("won't overwrite existing file %q", outFile)
+				}
+				if w, err = os.Create(outFile); err != nil {
+					return err
+				}
+				defer w.Close()
+			}
+			enc := yaml.NewEncoder(w)
+			enc.SetIndent(2)
+			if err = enc.Encode(c); err != nil {
 				return err
 			}
+			return enc.Close()
+		},
+		SilenceUsage: true,
+	}
+	c.Flags().StringVar(
+		&outFile, "out", "", "file to write the config to, instead of stdout")
+	c.Flags().StringVar(
+		&rootPath, "path", "", "value for the config's root path field")
+	return c
+}
+
+func newExecCommand(flags *sharedFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "exec [{configFile}] -- {command} [{arg}...]",
+		Short: execHelp,
+		Long: execHelp + `
+
+  The command runs in each cloned repo's directory, subject to the
+  git server's timeout.  Its output is grouped by repo.  A summary
+  follows, and the exit code is non-zero if the command failed in
+  any repo.  Repos that aren't cloned are skipped.`,
+		Example: "  myrepos exec -j 8 -- go test ./...",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			k := cmd.ArgsLenAtDash()
+			if k < 0 || k == len(args) {
+				return fmt.Errorf("specify a command to run after '--'")
+			}
+			var cfg []*config.Config
+			if err := flags.configArgs(&cfg)(cmd, args[:k]); err != nil {
+				return err
+			}
+			var summary visitor.ExecSummary
 			for i := range cfg {
-				t, err := tree.MakeRootNode(cfg[i])
+				t, err := flags.makeTree(cfg[i])
 				if err != nil {
 					return err
 				}
-				v := visitor.Cloner{}
-				t.Accept(&v)
+				v := visitor.NewExecutor(cmd.Context(), flags.jobs, &summary, args[k], args[k+1:]...)
+				t.Accept(v)
 				if err = v.Err(); err != nil {
 					return err
 				}
 			}
+			fmt.Println(summary.String())
+			if len(summary.Failed) > 0 {
+				return fmt.Errorf("command failed in %d repos", len(summary.Failed))
+			}
 			return nil
 		},
 		SilenceUsage: true,
 	}
 }
 
-func main() {
-	if err := newCommand().Execute(); err != nil {
-		os.Exit(1)
+func newValidateCommand(flags *sharedFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [{configFile}]",
+		Short: validateHelp,
+		Long: validateHelp + `
+
+  Reports every problem found, e.g. unknown keys, bad durations,
+  schemes or ports, and malformed org or repo names, as
+  file:line:column: message.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths, err := file.GetFilePath(args)
+			if err != nil {
+				return err
+			}
+			sets := [][]file.Path{paths}
+			if !flags.merge {
+				sets = make([][]file.Path, len(paths))
+				for i := range paths {
+					sets[i] = paths[i : i+1]
+				}
+			}
+			count := 0
+			for i := range sets {
+				var c *config.Config
+				if c, err = config.Load(sets[i]...); err == nil {
+					// Catch anything the loader missed.
+					_, err = tree.MakeRootNode(c)
+				}
+				if err != nil {
+					fmt.Println(err)
+					count++
+				}
+			}
+			if count > 0 {
+				return fmt.Errorf("%d of %d configs have problems", count, len(sets))
+			}
+			fmt.Printf("%d configs ok\n", len(sets))
+			return nil
+		},
+		SilenceUsage: true,
 	}
-	os.Exit(0)
 }
 
-func loadConfig(p file.Path) (*config.Config, error) {
-	body, err := os.ReadFile(string(p))
+// errVisitor is a tree.Visitor that remembers its most recent error.
+type errVisitor interface {
+	tree.Visitor
+	Err() error
+}
+
+func main() {
+	// On SIGINT or SIGTERM, kill running git processes and stop.
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := newCommand().ExecuteContext(ctx)
+	stop()
 	if err != nil {
-		return nil, fmt.Errorf("unable to read config file %q", p)
-	}
-	var c config.Config
-	if err = yaml.Unmarshal(body, &c); err != nil {
-		return nil, err
+		os.Exit(1)
 	}
-	return &c, nil
+	os.Exit(0)
 }